@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -52,3 +54,48 @@ func LoadConfig() (*Config, error) {
 
 	return &cfg, nil
 }
+
+// LoadProjectList reads paths of projects recorded at the last sync. An empty
+// list is returned if nothing is recorded yet.
+func LoadProjectList() ([]string, error) {
+	listFile := filepath.Join(ConfDir, "project.list")
+	f, err := os.Open(listFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Fail to open file: %s", err)
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Fail to read file: %s", err)
+	}
+
+	return paths, nil
+}
+
+func SaveProjectList(paths []string) error {
+	listFile := filepath.Join(ConfDir, "project.list")
+	f, err := os.Create(listFile)
+	if err != nil {
+		return fmt.Errorf("Fail to create file: %s", err)
+	}
+	defer f.Close()
+
+	for _, p := range paths {
+		if _, err := fmt.Fprintln(f, p); err != nil {
+			return fmt.Errorf("Fail to write file: %s", err)
+		}
+	}
+
+	return nil
+}
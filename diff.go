@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var CmdDiff = cli.Command{
+	Name:      "diff",
+	Usage:     "Show changes in the worktree of repositories",
+	ArgsUsage: "[projects...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "manifest",
+			Usage:   "Show changes relative to 'manifest-rev' instead of HEAD",
+			Aliases: []string{"m"},
+		},
+		&cli.BoolFlag{
+			Name:  "stat",
+			Usage: "Show a summary of changed files instead of the patch",
+		},
+	},
+	Action: cmdDiff,
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+func cmdDiff(ctx *cli.Context) error {
+	dlog := log.WithFields(log.Fields{
+		"cmd": "diff",
+	})
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	projects, err := selectProjects(m, ctx.Args().Slice(), "", "")
+	if err != nil {
+		return fmt.Errorf("Fail to select projects: %s", err)
+	}
+
+	var stats []diffStat
+	for _, p := range projects {
+		plog := dlog.WithFields(log.Fields{
+			"project": p.Path,
+		})
+		patch, err := repoDiff(p.Path, ctx.Bool("manifest"))
+		if err != nil {
+			plog.Errorf("Fail to diff: %s", err)
+			continue
+		}
+
+		if ctx.Bool("stat") {
+			stats = append(stats, patchStats(patch)...)
+			continue
+		}
+
+		err = fdiff.NewUnifiedEncoder(os.Stdout, fdiff.DefaultContextLines).Encode(patch)
+		if err != nil {
+			return fmt.Errorf("Fail to print diff: %s", err)
+		}
+	}
+
+	if ctx.Bool("stat") {
+		printDiffStat(os.Stdout, stats)
+	}
+
+	return nil
+}
+
+// The types below implement the interfaces of go-git's diff package, so that
+// the unified encoder can be used to print worktree changes.
+
+type diffFile struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+	path string
+}
+
+func (f *diffFile) Hash() plumbing.Hash     { return f.hash }
+func (f *diffFile) Mode() filemode.FileMode { return f.mode }
+func (f *diffFile) Path() string            { return f.path }
+
+type diffChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c *diffChunk) Content() string       { return c.content }
+func (c *diffChunk) Type() fdiff.Operation { return c.op }
+
+type diffFilePatch struct {
+	from     *diffFile
+	to       *diffFile
+	isBinary bool
+	chunks   []fdiff.Chunk
+}
+
+func (p *diffFilePatch) IsBinary() bool        { return p.isBinary }
+func (p *diffFilePatch) Chunks() []fdiff.Chunk { return p.chunks }
+
+func (p *diffFilePatch) Files() (fdiff.File, fdiff.File) {
+	// Nil pointers must be turned into nil interfaces explicitly
+	var from, to fdiff.File
+	if p.from != nil {
+		from = p.from
+	}
+	if p.to != nil {
+		to = p.to
+	}
+
+	return from, to
+}
+
+type diffPatch struct {
+	filePatches []fdiff.FilePatch
+}
+
+func (p *diffPatch) FilePatches() []fdiff.FilePatch { return p.filePatches }
+func (p *diffPatch) Message() string                { return "" }
+
+// readBase reads a file from the tree of the base commit.
+func readBase(tree *object.Tree, name string) (*diffFile, []byte, error) {
+	f, err := tree.File(name)
+	if err != nil {
+		if errors.Is(err, object.ErrFileNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	r, err := f.Reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &diffFile{hash: f.Hash, mode: f.Mode}, content, nil
+}
+
+// readWorktree reads a file from the worktree of the repo.
+func readWorktree(repoPath, name string) (*diffFile, []byte, error) {
+	p := filepath.Join(repoPath, name)
+	fi, err := os.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var content []byte
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		content = []byte(target)
+	} else {
+		content, err = os.ReadFile(p)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	mode, err := filemode.NewFromOSFileMode(fi.Mode())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := &diffFile{
+		hash: plumbing.ComputeHash(plumbing.BlobObject, content),
+		mode: mode,
+	}
+
+	return f, content, nil
+}
+
+func isBinary(content []byte) bool {
+	b, _ := binary.IsBinary(bytes.NewReader(content))
+	return b
+}
+
+func fileDiff(tree *object.Tree, repoPath, prefix, name string) (*diffFilePatch, error) {
+	from, fromContent, err := readBase(tree, name)
+	if err != nil {
+		return nil, err
+	}
+
+	to, toContent, err := readWorktree(repoPath, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if from == nil && to == nil {
+		return nil, nil
+	}
+	if from != nil && to != nil && from.hash == to.hash && from.mode == to.mode {
+		return nil, nil
+	}
+
+	fp := &diffFilePatch{
+		from: from,
+		to:   to,
+	}
+	if from != nil {
+		from.path = prefix + name
+	}
+	if to != nil {
+		to.path = prefix + name
+	}
+
+	if isBinary(fromContent) || isBinary(toContent) {
+		fp.isBinary = true
+		return fp, nil
+	}
+
+	diffs := diff.Do(string(fromContent), string(toContent))
+	for _, d := range diffs {
+		var op fdiff.Operation
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			op = fdiff.Equal
+		case diffmatchpatch.DiffInsert:
+			op = fdiff.Add
+		case diffmatchpatch.DiffDelete:
+			op = fdiff.Delete
+		}
+		fp.chunks = append(fp.chunks, &diffChunk{content: d.Text, op: op})
+	}
+
+	return fp, nil
+}
+
+// repoDiff compares the worktree of a project with HEAD, or with
+// 'manifest-rev' if useManifest is set. Untracked files are ignored. Paths in
+// the patch are prefixed with the project path.
+func repoDiff(relPath string, useManifest bool) (*diffPatch, error) {
+	repoPath := filepath.Join(ProjectRoot, relPath)
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to open repo: %s", err)
+	}
+
+	headRef, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("Fail to resolve HEAD: %s", err)
+	}
+
+	baseHash := headRef.Hash()
+	if useManifest {
+		ref, err := findBranch(repo, "manifest-rev")
+		if err != nil {
+			return nil, err
+		}
+		baseHash = ref.Hash()
+	}
+
+	baseCommit, err := repo.CommitObject(baseHash)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to read commit: %s", err)
+	}
+	baseTree, err := baseCommit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("Fail to read tree: %s", err)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get worktree: %s", err)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get worktree status: %s", err)
+	}
+
+	names := make(map[string]bool)
+	for name, s := range status {
+		if s.Worktree == git.Untracked {
+			continue
+		}
+		names[name] = true
+	}
+
+	// Files changed between 'manifest-rev' and HEAD are part of the diff as well
+	if baseHash != headRef.Hash() {
+		headCommit, err := repo.CommitObject(headRef.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("Fail to read commit: %s", err)
+		}
+		headTree, err := headCommit.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("Fail to read tree: %s", err)
+		}
+
+		changes, err := object.DiffTree(baseTree, headTree)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to compare trees: %s", err)
+		}
+		for _, c := range changes {
+			if c.From.Name != "" {
+				names[c.From.Name] = true
+			}
+			if c.To.Name != "" {
+				names[c.To.Name] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	prefix := filepath.ToSlash(filepath.Clean(relPath)) + "/"
+	patch := &diffPatch{}
+	for _, name := range sorted {
+		fp, err := fileDiff(baseTree, repoPath, prefix, name)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to diff %s: %s", name, err)
+		}
+		if fp == nil {
+			continue
+		}
+		patch.filePatches = append(patch.filePatches, fp)
+	}
+
+	return patch, nil
+}
+
+type diffStat struct {
+	path     string
+	isBinary bool
+	added    int
+	deleted  int
+}
+
+func countLines(s string) int {
+	if s == "" {
+		return 0
+	}
+
+	n := strings.Count(s, "\n")
+	if !strings.HasSuffix(s, "\n") {
+		n++
+	}
+
+	return n
+}
+
+func patchStats(patch *diffPatch) []diffStat {
+	var stats []diffStat
+	for _, fp := range patch.filePatches {
+		from, to := fp.Files()
+		s := diffStat{
+			isBinary: fp.IsBinary(),
+		}
+		if to != nil {
+			s.path = to.Path()
+		} else {
+			s.path = from.Path()
+		}
+
+		for _, c := range fp.Chunks() {
+			switch c.Type() {
+			case fdiff.Add:
+				s.added += countLines(c.Content())
+			case fdiff.Delete:
+				s.deleted += countLines(c.Content())
+			}
+		}
+		stats = append(stats, s)
+	}
+
+	return stats
+}
+
+func printDiffStat(w io.Writer, stats []diffStat) {
+	const maxWidth = 50
+
+	nameWidth := 0
+	maxChanges := 0
+	for _, s := range stats {
+		if len(s.path) > nameWidth {
+			nameWidth = len(s.path)
+		}
+		if s.added+s.deleted > maxChanges {
+			maxChanges = s.added + s.deleted
+		}
+	}
+
+	totalAdded := 0
+	totalDeleted := 0
+	for _, s := range stats {
+		totalAdded += s.added
+		totalDeleted += s.deleted
+
+		if s.isBinary {
+			fmt.Fprintf(w, " %-*s | Bin\n", nameWidth, s.path)
+			continue
+		}
+
+		// Scale the graph down if it doesn't fit
+		added, deleted := s.added, s.deleted
+		if maxChanges > maxWidth {
+			added = added * maxWidth / maxChanges
+			deleted = deleted * maxWidth / maxChanges
+		}
+		fmt.Fprintf(w, " %-*s | %d %s%s\n", nameWidth, s.path, s.added+s.deleted,
+			strings.Repeat("+", added), strings.Repeat("-", deleted))
+	}
+
+	fmt.Fprintf(w, " %d files changed, %d insertions(+), %d deletions(-)\n",
+		len(stats), totalAdded, totalDeleted)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// The version of kas project files which are exported
+const kasHeaderVersion = 14
+
+var CmdManifest = cli.Command{
+	Name:  "manifest",
+	Usage: "Export the workspace as a manifest pinned to the current revisions",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "The manifest format: xml, kas",
+			Value: "xml",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Usage:   "The file to write, or '-' for stdout",
+			Value:   "-",
+			Aliases: []string{"o"},
+		},
+	},
+	Action: cmdManifest,
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+// exportProject is a project with its current state in the workspace.
+type exportProject struct {
+	Project
+	remote   string
+	url      string
+	revision string
+	// The current HEAD, or empty if it's not synced
+	commit string
+	layers []string
+}
+
+type xmlManifest struct {
+	XMLName  xml.Name     `xml:"manifest"`
+	Remotes  []xmlRemote  `xml:"remote"`
+	Default  *xmlDefault  `xml:"default"`
+	Projects []xmlProject `xml:"project"`
+}
+
+type xmlRemote struct {
+	Name  string `xml:"name,attr"`
+	Fetch string `xml:"fetch,attr"`
+}
+
+type xmlDefault struct {
+	Remote   string `xml:"remote,attr,omitempty"`
+	Revision string `xml:"revision,attr,omitempty"`
+	SyncJ    string `xml:"sync-j,attr,omitempty"`
+}
+
+type xmlProject struct {
+	Name        string       `xml:"name,attr"`
+	Path        string       `xml:"path,attr,omitempty"`
+	Remote      string       `xml:"remote,attr,omitempty"`
+	Revision    string       `xml:"revision,attr,omitempty"`
+	Upstream    string       `xml:"upstream,attr,omitempty"`
+	Groups      string       `xml:"groups,attr,omitempty"`
+	Copyfiles   []Copyfile   `xml:"copyfile"`
+	Linkfiles   []Linkfile   `xml:"linkfile"`
+	Annotations []Annotation `xml:"annotation"`
+}
+
+func cmdManifest(ctx *cli.Context) error {
+	mlog := log.WithFields(log.Fields{
+		"cmd": "manifest",
+	})
+	format := ctx.String("format")
+	if format != "xml" && format != "kas" {
+		return fmt.Errorf("Unknown format: %s", format)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	var projects []exportProject
+	for _, p := range m.Projects {
+		projects = append(projects, newExportProject(m, p, mlog))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if format == "kas" {
+		err = writeKas(buf, projects)
+	} else {
+		err = writeXml(buf, m, projects)
+	}
+	if err != nil {
+		return fmt.Errorf("Fail to export manifest: %s", err)
+	}
+
+	output := ctx.String("output")
+	if output == "-" {
+		os.Stdout.Write(buf.Bytes())
+		return nil
+	}
+
+	if err := os.WriteFile(output, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("Fail to write %s: %s", output, err)
+	}
+
+	return nil
+}
+
+// newExportProject reads the current state of the project. Projects which
+// are not synced keep their revisions in the manifest.
+func newExportProject(m *Manifest, p Project, mlog *log.Entry) exportProject {
+	e := exportProject{Project: p}
+	e.remote, e.url, _ = m.GetRemote(&p)
+	e.revision, _ = m.GetRevision(&p)
+
+	plog := mlog.WithFields(log.Fields{
+		"project": p.Path,
+	})
+	curRev, _, _, err := getRevs(m, &p)
+	if err != nil {
+		plog.Warnf("Keep the revision in the manifest: %s", err)
+		return e
+	}
+	e.commit = curRev
+
+	repo, err := git.PlainOpen(filepath.Join(ProjectRoot, p.Path))
+	if err != nil {
+		plog.Warnf("Fail to open repo: %s", err)
+		return e
+	}
+
+	// Revisions of west are tags if there are such tags
+	if p.TagFirst && !plumbing.IsHash(e.revision) && !strings.HasPrefix(e.revision, "refs/") {
+		if _, err := repo.Reference(plumbing.NewTagReferenceName(e.revision), false); err == nil {
+			e.revision = "refs/tags/" + e.revision
+		}
+	}
+
+	// Others can't fetch commits which only exist locally
+	pushed, err := isPushed(repo, e.remote, plumbing.NewHash(curRev))
+	if err != nil {
+		plog.Warnf("Fail to check if %s is pushed: %s", curRev, err)
+	} else if !pushed {
+		plog.Warnf("%s is not on any branch of remote %s, push it before using the manifest", curRev, e.remote)
+	}
+
+	// Layers given by the manifest, like kas, win over detected ones
+	e.layers = p.Layers
+	if len(e.layers) == 0 {
+		found, err := findLayers(filepath.Join(ProjectRoot, p.Path), projectDirs(m)...)
+		if err != nil {
+			plog.Warnf("Fail to find layers: %s", err)
+		}
+		for _, l := range found {
+			if !testLayers[path.Base(l)] {
+				e.layers = append(e.layers, l)
+			}
+		}
+	}
+
+	return e
+}
+
+// isPushed checks if the commit is reachable from any remote-tracking branch
+// of the remote.
+func isPushed(repo *git.Repository, remote string, commit plumbing.Hash) (bool, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return false, err
+	}
+	defer refs.Close()
+
+	prefix := fmt.Sprintf("refs/remotes/%s/", remote)
+	var branches []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && strings.HasPrefix(ref.Name().String(), prefix) {
+			branches = append(branches, ref.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, h := range branches {
+		if h == commit {
+			return true, nil
+		}
+	}
+
+	for _, h := range branches {
+		localOnly, _, err := uniqueCommits(repo, commit, h)
+		if err != nil {
+			return false, err
+		}
+		if len(localOnly) == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// fetchBase splits the URL of a project into the fetch URL of its remote and
+// the name of the project.
+func fetchBase(rawUrl string) (string, string) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Path == "" {
+		i := strings.LastIndex(rawUrl, "/")
+		return rawUrl[:i+1], rawUrl[i+1:]
+	}
+
+	name := path.Base(u.Path)
+	u.Path = path.Dir(u.Path)
+	return u.String(), name
+}
+
+func writeXml(w io.Writer, m *Manifest, projects []exportProject) error {
+	out := xmlManifest{
+		Remotes: append([]xmlRemote{}, toXmlRemotes(m.Remotes)...),
+	}
+	if m.Defaults.Remote != "" || m.Defaults.Revision != "" || m.Defaults.SyncJ != "" {
+		out.Default = &xmlDefault{
+			Remote:   m.Defaults.Remote,
+			Revision: m.Defaults.Revision,
+			SyncJ:    m.Defaults.SyncJ,
+		}
+	}
+
+	// Projects with their own URLs, e.g. from kas, get remotes by their
+	// fetch URLs
+	remoteNames := make(map[string]string)
+	for _, p := range projects {
+		xp := xmlProject{
+			Name:        p.Name,
+			Path:        p.Path,
+			Remote:      p.Remote,
+			Revision:    p.Revision,
+			Groups:      p.Groups,
+			Copyfiles:   p.Copyfiles,
+			Linkfiles:   p.Linkfiles,
+			Annotations: p.Annotations,
+		}
+
+		if p.URL != "" {
+			fetch, name := fetchBase(p.URL)
+			remote, ok := remoteNames[fetch]
+			if !ok {
+				remote = urlRemoteName
+				if len(remoteNames) > 0 {
+					remote = fmt.Sprintf("%s%d", urlRemoteName, len(remoteNames))
+				}
+				remoteNames[fetch] = remote
+				out.Remotes = append(out.Remotes, xmlRemote{Name: remote, Fetch: fetch})
+			}
+			xp.Name = name
+			xp.Remote = remote
+		}
+
+		if p.commit != "" && p.commit != p.revision {
+			xp.Revision = p.commit
+			if p.revision != remoteHeadRevision {
+				xp.Upstream = p.revision
+			}
+		}
+
+		// Detected layers are kept as the annotation used by 'yocto bblayers'
+		if len(p.layers) > 0 {
+			if _, ok := p.GetAnnotation(bblayersAnnotation); !ok {
+				xp.Annotations = append(xp.Annotations, Annotation{
+					Name:  bblayersAnnotation,
+					Value: strings.Join(p.layers, ","),
+				})
+			}
+		}
+
+		out.Projects = append(out.Projects, xp)
+	}
+
+	data, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return nil
+}
+
+func toXmlRemotes(remotes []Remote) []xmlRemote {
+	var out []xmlRemote
+	for _, r := range remotes {
+		out = append(out, xmlRemote{Name: r.Name, Fetch: r.Fetch})
+	}
+
+	return out
+}
+
+func yamlScalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func yamlMap() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode}
+}
+
+func yamlAdd(m *yaml.Node, key string, value *yaml.Node) {
+	m.Content = append(m.Content, yamlScalar(key), value)
+}
+
+// writeKas writes a kas project file with repos pinned to their commits. The
+// yaml nodes are built by hand to keep the order of keys.
+func writeKas(w io.Writer, projects []exportProject) error {
+	header := yamlMap()
+	yamlAdd(header, "version", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(kasHeaderVersion)})
+
+	repos := yamlMap()
+	names := make(map[string]bool)
+	for _, p := range projects {
+		r := yamlMap()
+		yamlAdd(r, "url", yamlScalar(p.url))
+		yamlAdd(r, "path", yamlScalar(p.Path))
+
+		rev := p.revision
+		if strings.HasPrefix(rev, "refs/tags/") {
+			yamlAdd(r, "tag", yamlScalar(strings.TrimPrefix(rev, "refs/tags/")))
+		} else if rev != "" && rev != remoteHeadRevision && !plumbing.IsHash(rev) {
+			yamlAdd(r, "branch", yamlScalar(strings.TrimPrefix(rev, "refs/heads/")))
+		}
+
+		commit := p.commit
+		if commit == "" && plumbing.IsHash(rev) {
+			commit = rev
+		}
+		if commit != "" {
+			yamlAdd(r, "commit", yamlScalar(commit))
+		}
+
+		// The repo itself is the layer by default, so it's excluded if
+		// there's no layer, e.g. bitbake or repos not synced
+		if len(p.layers) == 0 {
+			layers := yamlMap()
+			yamlAdd(layers, ".", yamlScalar("excluded"))
+			yamlAdd(r, "layers", layers)
+		} else if !(len(p.layers) == 1 && p.layers[0] == ".") {
+			layers := yamlMap()
+			for _, l := range p.layers {
+				yamlAdd(layers, l, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"})
+			}
+			yamlAdd(r, "layers", layers)
+		}
+
+		// Repos are named after projects, or their paths if names collide
+		name := strings.TrimSuffix(path.Base(p.Name), ".git")
+		if names[name] {
+			name = strings.ReplaceAll(filepath.ToSlash(p.Path), "/", "-")
+		}
+		names[name] = true
+		yamlAdd(repos, name, r)
+	}
+
+	doc := yamlMap()
+	yamlAdd(doc, "header", header)
+	yamlAdd(doc, "repos", repos)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+
+	return encoder.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{doc}})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteKas(t *testing.T) {
+	commit := "d5c2c0246acaa49baf0a9b63597b375734139e26"
+	projects := []exportProject{
+		{
+			Project:  Project{Name: "poky", Path: "layers/poky"},
+			url:      "https://example.com/poky.git",
+			revision: "kirkstone",
+			commit:   commit,
+			layers:   []string{"meta", "meta-poky"},
+		},
+		{
+			Project:  Project{Name: "bitbake", Path: "bitbake"},
+			url:      "https://example.com/bitbake.git",
+			revision: remoteHeadRevision,
+			commit:   commit,
+		},
+		{
+			Project:  Project{Name: "meta-foo", Path: "layers/meta-foo"},
+			url:      "https://example.com/meta-foo.git",
+			revision: "refs/tags/v1.0",
+			layers:   []string{"."},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := writeKas(buf, projects); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `header:
+  version: 14
+repos:
+  poky:
+    url: https://example.com/poky.git
+    path: layers/poky
+    branch: kirkstone
+    commit: ` + commit + `
+    layers:
+      meta:
+      meta-poky:
+  bitbake:
+    url: https://example.com/bitbake.git
+    path: bitbake
+    commit: ` + commit + `
+    layers:
+      .: excluded
+  meta-foo:
+    url: https://example.com/meta-foo.git
+    path: layers/meta-foo
+    tag: v1.0
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
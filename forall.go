@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var CmdForall = cli.Command{
+	Name:      "forall",
+	Usage:     "Run a shell command in each project",
+	ArgsUsage: "[projects...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "command",
+			Usage:    "The shell command to run",
+			Aliases:  []string{"c"},
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:    "jobs",
+			Usage:   "How many commands are run in parallel",
+			Value:   1,
+			Aliases: []string{"j"},
+		},
+		&cli.BoolFlag{
+			Name:    "project-header",
+			Usage:   "Show a header with the project path before the output",
+			Aliases: []string{"p"},
+		},
+		&cli.BoolFlag{
+			Name:  "prefix",
+			Usage: "Prefix each line of the output with the project path",
+		},
+		&cli.BoolFlag{
+			Name:    "abort-on-errors",
+			Usage:   "Stop running the command in remaining projects if it fails",
+			Aliases: []string{"e"},
+		},
+		&cli.StringFlag{
+			Name:    "groups",
+			Usage:   "Only run in projects of the groups (comma-separated, '-' prefix to exclude)",
+			Aliases: []string{"g"},
+		},
+		&cli.StringFlag{
+			Name:    "regex",
+			Usage:   "Only run in projects whose name or path matches the regex",
+			Aliases: []string{"r"},
+		},
+	},
+	Action: cmdForall,
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+type forallJob struct {
+	idx     int
+	count   int
+	project Project
+	remote  string
+	rev     string
+}
+
+type forallOptions struct {
+	command  string
+	header   bool
+	prefix   bool
+	buffered bool
+}
+
+func cmdForall(ctx *cli.Context) error {
+	flog := log.WithFields(log.Fields{
+		"cmd": "forall",
+	})
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	projects, err := selectProjects(m, ctx.Args().Slice(), ctx.String("groups"), ctx.String("regex"))
+	if err != nil {
+		return fmt.Errorf("Fail to select projects: %s", err)
+	}
+
+	n := ctx.Int("jobs")
+	if n <= 0 {
+		n = 1
+	}
+
+	opts := forallOptions{
+		command: ctx.String("command"),
+		header:  ctx.Bool("project-header"),
+		prefix:  ctx.Bool("prefix"),
+		// Output of parallel commands must not interleave
+		buffered: n > 1 || ctx.Bool("prefix"),
+	}
+
+	err = forallRepos(m, projects, n, opts, ctx.Bool("abort-on-errors"), flog)
+	if err != nil {
+		return fmt.Errorf("Fail to run command: %s", err)
+	}
+
+	return nil
+}
+
+func forallEnv(j forallJob, repoPath string) []string {
+	lrev := ""
+	if repo, err := git.PlainOpen(repoPath); err == nil {
+		if ref, err := findBranch(repo, "manifest-rev"); err == nil {
+			lrev = ref.Hash().String()
+		}
+	}
+
+	env := os.Environ()
+	env = append(env,
+		"REPO_PROJECT="+j.project.Name,
+		"REPO_PATH="+j.project.Path,
+		"REPO_REMOTE="+j.remote,
+		"REPO_RREV="+j.rev,
+		"REPO_LREV="+lrev,
+		"REPO_I="+strconv.Itoa(j.idx+1),
+		"REPO_COUNT="+strconv.Itoa(j.count),
+	)
+
+	return env
+}
+
+func writePrefixed(w io.Writer, r io.Reader, prefix string) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		fmt.Fprintf(w, "%s: %s\n", prefix, scanner.Text())
+	}
+}
+
+// runForall runs the command of a job. Unbuffered output goes to stdout
+// directly, otherwise it's written out at once when the command is finished.
+func runForall(j forallJob, opts forallOptions, outMutex *sync.Mutex) error {
+	repoPath := filepath.Join(ProjectRoot, j.project.Path)
+	if !isDir(repoPath) {
+		return fmt.Errorf("The project %s is not synced", j.project.Path)
+	}
+
+	cmd := exec.Command("sh", "-c", opts.command)
+	cmd.Dir = repoPath
+	cmd.Env = forallEnv(j, repoPath)
+
+	buf := bytes.NewBuffer(nil)
+	if opts.buffered {
+		cmd.Stdout = buf
+		cmd.Stderr = buf
+	} else {
+		if opts.header {
+			fmt.Printf("project %s/\n", j.project.Path)
+		}
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	err := cmd.Run()
+
+	if opts.buffered {
+		outMutex.Lock()
+		if opts.header && buf.Len() > 0 {
+			fmt.Printf("project %s/\n", j.project.Path)
+		}
+		if opts.prefix {
+			writePrefixed(os.Stdout, buf, j.project.Path)
+		} else {
+			os.Stdout.Write(buf.Bytes())
+		}
+		outMutex.Unlock()
+	}
+
+	return err
+}
+
+func forallRepos(m *Manifest, projects []Project, numTasks int, opts forallOptions, abortOnErrors bool, flog *log.Entry) error {
+	jobCh := make(chan forallJob)
+	var wg sync.WaitGroup
+	var outMutex sync.Mutex
+	var errMutex sync.Mutex
+	failed := 0
+	aborted := false
+
+	for i := 0; i < numTasks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				errMutex.Lock()
+				stop := aborted
+				errMutex.Unlock()
+				if stop {
+					continue
+				}
+
+				jlog := flog.WithFields(log.Fields{
+					"path": j.project.Path,
+				})
+				err := runForall(j, opts, &outMutex)
+				if err != nil {
+					jlog.Errorf("Command failed: %s", err)
+					errMutex.Lock()
+					failed++
+					if abortOnErrors {
+						aborted = true
+					}
+					errMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i, p := range projects {
+		errMutex.Lock()
+		stop := aborted
+		errMutex.Unlock()
+		if stop {
+			break
+		}
+
+		j := forallJob{
+			idx:     i,
+			count:   len(projects),
+			project: p,
+		}
+		j.remote, _, _ = m.GetRemote(&p)
+		j.rev, _ = m.GetRevision(&p)
+
+		jobCh <- j
+	}
+	close(jobCh)
+	wg.Wait()
+
+	if aborted {
+		flog.Warn("Abort on errors")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Command failed in %d project(s)", failed)
+	}
+
+	return nil
+}
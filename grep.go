@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var CmdGrep = cli.Command{
+	Name:      "grep",
+	Usage:     "Search tracked files of repositories",
+	ArgsUsage: "<pattern> [projects...]",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "regexp",
+			Usage:   "The pattern to search for. All arguments are treated as projects if it's given",
+			Aliases: []string{"e"},
+		},
+		&cli.BoolFlag{
+			Name:    "ignore-case",
+			Usage:   "Ignore case distinctions",
+			Aliases: []string{"i"},
+		},
+		&cli.BoolFlag{
+			Name:    "files-with-matches",
+			Usage:   "Only print names of files containing matches",
+			Aliases: []string{"l"},
+		},
+		&cli.BoolFlag{
+			Name:    "line-number",
+			Usage:   "Prefix matched lines with line numbers",
+			Aliases: []string{"n"},
+		},
+		&cli.BoolFlag{
+			Name:    "worktree",
+			Usage:   "Search tracked files in the worktree instead of HEAD",
+			Aliases: []string{"w"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "path",
+			Usage:   "Only search files matching the pathspec (directory or glob)",
+			Aliases: []string{"p"},
+		},
+		&cli.IntFlag{
+			Name:        "jobs",
+			Usage:       "How many projects are searched in parallel",
+			DefaultText: "number of CPUs",
+			Aliases:     []string{"j"},
+		},
+	},
+	Action: cmdGrep,
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+type grepOptions struct {
+	patterns   []*regexp.Regexp
+	pathspecs  []string
+	filesOnly  bool
+	lineNumber bool
+	worktree   bool
+}
+
+// cmdGrep exits with 1 if nothing matches, or 2 for any error, so that they
+// can be told apart.
+func cmdGrep(ctx *cli.Context) error {
+	err := grepProjects(ctx)
+	var exitErr *exitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return &exitError{err: err, code: ExitGrepFailed}
+	}
+
+	return err
+}
+
+func grepProjects(ctx *cli.Context) error {
+	glog := log.WithFields(log.Fields{
+		"cmd": "grep",
+	})
+	args := ctx.Args().Slice()
+	exprs := ctx.StringSlice("regexp")
+	if len(exprs) == 0 {
+		if len(args) == 0 {
+			return fmt.Errorf("No pattern is specified")
+		}
+		exprs = args[:1]
+		args = args[1:]
+	}
+
+	opts := grepOptions{
+		pathspecs:  ctx.StringSlice("path"),
+		filesOnly:  ctx.Bool("files-with-matches"),
+		lineNumber: ctx.Bool("line-number"),
+		worktree:   ctx.Bool("worktree"),
+	}
+	for _, e := range exprs {
+		if ctx.Bool("ignore-case") {
+			e = "(?i)" + e
+		}
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return fmt.Errorf("Invalid pattern: %s", err)
+		}
+		opts.patterns = append(opts.patterns, re)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	projects, err := selectProjects(m, args, "", "")
+	if err != nil {
+		return fmt.Errorf("Fail to select projects: %s", err)
+	}
+
+	n := ctx.Int("jobs")
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	// Results are buffered, so that they are printed in manifest order
+	results := make([]*bytes.Buffer, len(projects))
+	errs := make([]error, len(projects))
+	parallelDo(n, len(projects), func(i int) {
+		p := projects[i]
+		results[i] = bytes.NewBuffer(nil)
+		errs[i] = grepRepo(results[i], p.Path, opts)
+		if errs[i] != nil {
+			glog.WithFields(log.Fields{
+				"project": p.Path,
+			}).Errorf("Fail to search: %s", errs[i])
+		}
+	})
+
+	matched := false
+	for _, r := range results {
+		if r.Len() > 0 {
+			matched = true
+		}
+		os.Stdout.Write(r.Bytes())
+	}
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+
+	// Errors win over matches, like git grep
+	if failed > 0 {
+		return &exitError{
+			err:  fmt.Errorf("Fail to search %d project(s)", failed),
+			code: ExitGrepFailed,
+		}
+	}
+	if !matched {
+		return &exitError{code: ExitGrepNoMatch}
+	}
+
+	return nil
+}
+
+// matchPathspec checks whether the file matches any of the pathspecs, which
+// are either directories or glob patterns. Empty pathspecs match everything.
+func matchPathspec(name string, pathspecs []string) bool {
+	if len(pathspecs) == 0 {
+		return true
+	}
+
+	for _, spec := range pathspecs {
+		spec = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(spec)), "/")
+		if spec == "." || name == spec || strings.HasPrefix(name, spec+"/") {
+			return true
+		}
+		if ok, _ := filepath.Match(spec, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(spec, filepath.Base(name)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchLine(line string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// grepFile searches the content of a file and writes matches with the given
+// name to w.
+func grepFile(w io.Writer, name string, r io.Reader, opts grepOptions) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if !matchLine(line, opts.patterns) {
+			continue
+		}
+
+		if opts.filesOnly {
+			fmt.Fprintf(w, "%s\n", name)
+			return nil
+		}
+
+		if opts.lineNumber {
+			fmt.Fprintf(w, "%s:%d:%s\n", name, lineNum, line)
+		} else {
+			fmt.Fprintf(w, "%s:%s\n", name, line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func grepRepo(w io.Writer, relPath string, opts grepOptions) error {
+	repoPath := filepath.Join(ProjectRoot, relPath)
+	prefix := filepath.ToSlash(filepath.Clean(relPath)) + "/"
+
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("Fail to open repo: %s", err)
+	}
+
+	if opts.worktree {
+		return grepWorktree(w, repo, repoPath, prefix, opts)
+	}
+
+	headRef, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("Fail to resolve HEAD: %s", err)
+	}
+
+	commit, err := repo.CommitObject(headRef.Hash())
+	if err != nil {
+		return fmt.Errorf("Fail to read commit: %s", err)
+	}
+
+	files, err := commit.Files()
+	if err != nil {
+		return fmt.Errorf("Fail to read files: %s", err)
+	}
+
+	return files.ForEach(func(f *object.File) error {
+		if !f.Mode.IsFile() || !matchPathspec(f.Name, opts.pathspecs) {
+			return nil
+		}
+		if bin, err := f.IsBinary(); err != nil || bin {
+			return nil
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		return grepFile(w, prefix+f.Name, r, opts)
+	})
+}
+
+// grepWorktree searches the files in the index as they are in the worktree.
+func grepWorktree(w io.Writer, repo *git.Repository, repoPath, prefix string, opts grepOptions) error {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("Fail to read index: %s", err)
+	}
+
+	for _, e := range idx.Entries {
+		if !e.Mode.IsFile() || !matchPathspec(e.Name, opts.pathspecs) {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(repoPath, e.Name))
+		if err != nil {
+			// Deleted in the worktree
+			continue
+		}
+		if isBinary(content) {
+			continue
+		}
+
+		err = grepFile(w, prefix+e.Name, bytes.NewReader(content), opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
 )
 
 var CmdInfo = cli.Command{
@@ -21,17 +26,80 @@ var CmdInfo = cli.Command{
 			Name:  "show-url",
 			Usage: "Print URLs of repositories",
 		},
+		&cli.IntFlag{
+			Name:        "jobs",
+			Usage:       "How many projects are read in parallel",
+			DefaultText: "number of CPUs",
+			Aliases:     []string{"j"},
+		},
+		&cli.BoolFlag{
+			Name:  "only-diverged",
+			Usage: "Only list projects whose HEAD differs from the manifest revision",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "The output format: table, json, csv, markdown, yaml, html",
+			Value: "table",
+		},
 	},
 	Before: func(c *cli.Context) error {
 		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
 		return nil
 	},
 }
 
+// States of HEAD compared with the manifest revision
+const (
+	SyncInSync   = "in-sync"
+	SyncAhead    = "ahead"
+	SyncBehind   = "behind"
+	SyncDiverged = "diverged"
+)
+
+type manifestRepoInfo struct {
+	Path string `json:"path" yaml:"path"`
+	Head string `json:"head" yaml:"head"`
+}
+
+type projectInfo struct {
+	Path       string   `json:"path" yaml:"path"`
+	Name       string   `json:"name" yaml:"name"`
+	Remote     string   `json:"remote" yaml:"remote"`
+	Url        string   `json:"url" yaml:"url"`
+	Head       string   `json:"head" yaml:"head"`
+	Revision   string   `json:"revision" yaml:"revision"`
+	Resolved   string   `json:"resolved" yaml:"resolved"`
+	Branch     string   `json:"branch" yaml:"branch"`
+	Dirty      bool     `json:"dirty" yaml:"dirty"`
+	InSync     bool     `json:"in-sync" yaml:"in-sync"`
+	State      string   `json:"state" yaml:"state"`
+	Ahead      int      `json:"ahead" yaml:"ahead"`
+	Behind     int      `json:"behind" yaml:"behind"`
+	LastSynced string   `json:"last-synced,omitempty" yaml:"last-synced,omitempty"`
+	Layers     []string `json:"layers,omitempty" yaml:"layers,omitempty"`
+	Error      string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type infoReport struct {
+	Manifest manifestRepoInfo `json:"manifest" yaml:"manifest"`
+	Projects []projectInfo    `json:"projects" yaml:"projects"`
+}
+
 func cmdInfo(ctx *cli.Context) error {
 	ilog := log.WithFields(log.Fields{
 		"cmd": "info",
 	})
+	format := ctx.String("format")
+	switch format {
+	case "table", "json", "csv", "markdown", "yaml", "html":
+	default:
+		return fmt.Errorf("Unknown format: %s", format)
+	}
+
 	cfg, err := LoadConfig()
 	if err != nil {
 		return fmt.Errorf("Fail to load config: %s", err)
@@ -43,22 +111,42 @@ func cmdInfo(ctx *cli.Context) error {
 		return fmt.Errorf("Fail to load manifest: %s", err)
 	}
 
-	showUrl := ctx.Bool("show-url")
-	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
-	if showUrl {
-		t.AppendHeader(table.Row{"Path", "Current revision", "Manifest revision", "Url"})
-	} else {
-		t.AppendHeader(table.Row{"Path", "Current revision", "Manifest revision"})
+	st, err := LoadState()
+	if err != nil {
+		return fmt.Errorf("Fail to load state: %s", err)
 	}
 
 	manifestRepo := filepath.Join(ConfDir, cfg.Manifest.Path)
-	err = manifestInfo(t, manifestRepo)
+	mi, err := manifestInfo(manifestRepo)
 	if err != nil {
 		return fmt.Errorf("Fail to list manifest info: %s", err)
 	}
 
-	err = repoInfo(t, m, ilog, showUrl)
+	n := ctx.Int("jobs")
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	// The worktree status is slow on big repos, and isn't shown in tables
+	infos := repoInfo(m, st, n, format != "table", ilog)
+	if ctx.Bool("only-diverged") {
+		var diverged []projectInfo
+		for _, info := range infos {
+			if info.Error == "" && !info.InSync {
+				diverged = append(diverged, info)
+			}
+		}
+		infos = diverged
+	}
+
+	switch format {
+	case "json", "yaml":
+		err = printInfoData(format, infoReport{Manifest: mi, Projects: infos})
+	case "table":
+		printInfoTable(mi, infos, ctx.Bool("show-url"))
+	default:
+		printInfoFields(format, infos)
+	}
 	if err != nil {
 		return fmt.Errorf("Fail to list repo info: %s", err)
 	}
@@ -66,60 +154,264 @@ func cmdInfo(ctx *cli.Context) error {
 	return nil
 }
 
-func manifestInfo(t table.Writer, repoPath string) error {
+func manifestInfo(repoPath string) (manifestRepoInfo, error) {
 	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
-		return fmt.Errorf("Fail to open manifest repo: %s", err)
+		return manifestRepoInfo{}, fmt.Errorf("Fail to open manifest repo: %s", err)
 	}
 
 	rev, err := repo.ResolveRevision(plumbing.Revision("HEAD"))
 	if err != nil {
-		return fmt.Errorf("Fail to resolve manifest revision: %s", err)
+		return manifestRepoInfo{}, fmt.Errorf("Fail to resolve manifest revision: %s", err)
 	}
 
-	t.AppendRow(table.Row{
-		filepath.Base(repoPath),
-		rev.String(),
+	return manifestRepoInfo{
+		Path: filepath.Base(repoPath),
+		Head: rev.String(),
+	}, nil
+}
+
+// repoInfo reads info of projects in parallel. Projects which fail are logged,
+// and kept with the error. Dirty is only set if withDirty is true.
+func repoInfo(m *Manifest, st *State, numTasks int, withDirty bool, ilog *log.Entry) []projectInfo {
+	// Results are stored by index, so that they are in manifest order
+	infos := make([]projectInfo, len(m.Projects))
+	parallelDo(numTasks, len(m.Projects), func(i int) {
+		infos[i] = getInfo(m, &m.Projects[i], withDirty)
 	})
-	t.AppendSeparator()
+
+	for i := range infos {
+		info := &infos[i]
+		if info.Error != "" {
+			ilog.WithFields(log.Fields{
+				"project": info.Path,
+			}).Errorf("Fail to get rev: %s", info.Error)
+			continue
+		}
+
+		ilog.Debugf("%s, %s", info.Head, info.Revision)
+		info.LastSynced = lastSyncedString(st.GetProject(info.Path))
+	}
+
+	return infos
+}
+
+func getInfo(m *Manifest, p *Project, withDirty bool) projectInfo {
+	info := projectInfo{
+		Path:   p.Path,
+		Name:   p.Name,
+		Layers: p.Layers,
+	}
+	info.Remote, info.Url, _ = m.GetRemote(p)
+
+	var err error
+	info.Head, info.Revision, info.Resolved, err = getRevs(m, p)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.InSync = info.Head == info.Resolved
+
+	repo, err := git.PlainOpen(filepath.Join(ProjectRoot, p.Path))
+	if err != nil {
+		info.Error = fmt.Sprintf("Fail to open repo: %s", err)
+		return info
+	}
+
+	if err := info.setSyncState(repo); err != nil {
+		info.Error = fmt.Sprintf("Fail to compare with manifest revision: %s", err)
+		return info
+	}
+
+	headRef, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		info.Error = fmt.Sprintf("Fail to read HEAD: %s", err)
+		return info
+	}
+	if headRef.Type() == plumbing.SymbolicReference {
+		info.Branch = headRef.Target().Short()
+	}
+
+	if !withDirty {
+		return info
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		info.Error = fmt.Sprintf("Fail to get worktree: %s", err)
+		return info
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		info.Error = fmt.Sprintf("Fail to get worktree status: %s", err)
+		return info
+	}
+	info.Dirty = !status.IsClean()
+
+	return info
+}
+
+func printInfoData(format string, report infoReport) error {
+	if format == "yaml" {
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(report)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// setSyncState compares HEAD with the resolved manifest revision.
+func (info *projectInfo) setSyncState(repo *git.Repository) error {
+	var err error
+	info.Ahead, info.Behind, err = aheadBehind(repo, plumbing.NewHash(info.Head), plumbing.NewHash(info.Resolved))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Ahead > 0 && info.Behind > 0:
+		info.State = SyncDiverged
+	case info.Ahead > 0:
+		info.State = SyncAhead
+	case info.Behind > 0:
+		info.State = SyncBehind
+	default:
+		info.State = SyncInSync
+	}
 
 	return nil
 }
 
-func repoInfo(t table.Writer, m *Manifest, ilog *log.Entry, showUrl bool) error {
-	for _, p := range m.Projects {
-		plog := ilog.WithFields(log.Fields{
-			"project": p.Path,
+// syncString describes how far HEAD is from the manifest revision.
+func (info *projectInfo) syncString() string {
+	switch info.State {
+	case SyncAhead:
+		return fmt.Sprintf("ahead %d", info.Ahead)
+	case SyncBehind:
+		return fmt.Sprintf("behind %d", info.Behind)
+	case SyncDiverged:
+		return fmt.Sprintf("diverged +%d/-%d", info.Ahead, info.Behind)
+	}
+
+	return "in sync"
+}
+
+// The column of the sync string in the table
+const infoSyncColumn = 3
+
+func printInfoTable(mi manifestRepoInfo, infos []projectInfo, showUrl bool) {
+	// Layers are only known from manifests like kas
+	showLayers := false
+	for _, info := range infos {
+		if len(info.Layers) > 0 {
+			showLayers = true
+		}
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	header := table.Row{"Path", "Current revision", "Manifest revision", "Sync", "Last synced"}
+	if showUrl {
+		header = append(header, "Url")
+	}
+	if showLayers {
+		header = append(header, "Layers")
+	}
+	t.AppendHeader(header)
+
+	// Projects out of sync are highlighted on a terminal
+	if isTerminal(os.Stdout) {
+		t.SetRowPainter(func(row table.Row) text.Colors {
+			if len(row) <= infoSyncColumn {
+				return nil
+			}
+
+			s, _ := row[infoSyncColumn].(string)
+			switch {
+			case s == "in sync":
+				return nil
+			case strings.HasPrefix(s, SyncDiverged):
+				return text.Colors{text.FgRed}
+			default:
+				return text.Colors{text.FgYellow}
+			}
 		})
-		curRev, manifestRev, manifestHash, err := getRevs(m, &p)
-		if err != nil {
-			plog.Errorf("Fail to get rev: %s", err)
+	}
+
+	t.AppendRow(table.Row{
+		mi.Path,
+		mi.Head,
+	})
+	t.AppendSeparator()
+
+	for _, info := range infos {
+		if info.Error != "" {
 			continue
 		}
 
-		ilog.Debugf("%s, %s", curRev, manifestRev)
+		row := table.Row{
+			info.Path,
+			info.Head,
+			revPrettyPrint(info.Revision, info.Resolved),
+			info.syncString(),
+			info.LastSynced,
+		}
 		if showUrl {
-			_, url, _ := m.GetRemote(&p)
-			t.AppendRow(table.Row{
-				p.Path,
-				curRev,
-				revPrettyPrint(manifestRev, manifestHash),
-				url,
-			})
-		} else {
-			t.AppendRow(table.Row{
-				p.Path,
-				curRev,
-				revPrettyPrint(manifestRev, manifestHash),
-			})
+			row = append(row, info.Url)
+		}
+		if showLayers {
+			row = append(row, strings.Join(info.Layers, ", "))
 		}
+		t.AppendRow(row)
 		//t.AppendSeparator()
 	}
 
-	t.AppendFooter(table.Row{"Total", len(m.Projects)})
+	t.AppendFooter(table.Row{"Total", len(infos)})
 	t.Render()
+}
 
-	return nil
+// printInfoFields renders all fields of projects in one of the table formats
+// of go-pretty other than the ASCII table.
+func printInfoFields(format string, infos []projectInfo) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Path", "Name", "Remote", "Url", "Head", "Revision", "Resolved",
+		"Branch", "Dirty", "In sync", "State", "Ahead", "Behind", "Last synced", "Layers", "Error"})
+
+	for _, info := range infos {
+		t.AppendRow(table.Row{
+			info.Path,
+			info.Name,
+			info.Remote,
+			info.Url,
+			info.Head,
+			info.Revision,
+			info.Resolved,
+			info.Branch,
+			info.Dirty,
+			info.InSync,
+			info.State,
+			info.Ahead,
+			info.Behind,
+			info.LastSynced,
+			strings.Join(info.Layers, " "),
+			info.Error,
+		})
+	}
+
+	switch format {
+	case "csv":
+		t.RenderCSV()
+	case "markdown":
+		t.RenderMarkdown()
+	case "html":
+		t.RenderHTML()
+	}
 }
 
 func revPrettyPrint(rev, hash string) string {
@@ -154,7 +446,7 @@ func getRevs(m *Manifest, p *Project) (curRev, manifestRev, manifestHash string,
 	}
 
 	remote, _, _ := m.GetRemote(p)
-	tmp, err := resolveRevision(repo, remote, manifestRev)
+	tmp, err := resolveProjectRevision(repo, remote, manifestRev, p.TagFirst)
 	if err != nil {
 		err = fmt.Errorf("Fail to resolve revision: %s", err)
 		return
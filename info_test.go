@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestSyncState(t *testing.T) {
+	h := newTestHistory(t)
+	base := h.chain(plumbing.ZeroHash, 10)
+	upstream := h.chain(base, 1)
+	local := h.chain(base, 2)
+
+	tests := []struct {
+		head, resolved plumbing.Hash
+		want           string
+	}{
+		{base, base, "in sync"},
+		{base, upstream, "behind 1"},
+		{upstream, base, "ahead 1"},
+		{local, upstream, "diverged +2/-1"},
+	}
+
+	for _, tt := range tests {
+		info := projectInfo{Head: tt.head.String(), Resolved: tt.resolved.String()}
+		if err := info.setSyncState(h.repo); err != nil {
+			t.Fatal(err)
+		}
+		if got := info.syncString(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
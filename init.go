@@ -22,7 +22,7 @@ var CmdInit = cli.Command{
 		},
 		&cli.StringFlag{
 			Name:    "manifest",
-			Usage:   "The xml file path",
+			Usage:   "The manifest file path (repo XML, kas or west YAML)",
 			Value:   "default.xml",
 			Aliases: []string{"m"},
 		},
@@ -44,6 +44,13 @@ var CmdInit = cli.Command{
 	Action: cmdInit,
 	Before: func(c *cli.Context) error {
 		SetProjectRoot(true)
+		if err := os.MkdirAll(ConfDir, 0755); err != nil {
+			return err
+		}
+		return AcquireLock(LockExclusive, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
 		return nil
 	},
 }
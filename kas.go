@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// The remote name of projects with their own URLs, as in kas and west
+const urlRemoteName = "origin"
+
+type kasConfig struct {
+	Defaults struct {
+		Repos kasRepo `yaml:"repos"`
+	} `yaml:"defaults"`
+	Repos map[string]*kasRepo `yaml:"repos"`
+}
+
+type kasRepo struct {
+	Url     string         `yaml:"url"`
+	Path    string         `yaml:"path"`
+	Refspec string         `yaml:"refspec"`
+	Branch  string         `yaml:"branch"`
+	Tag     string         `yaml:"tag"`
+	Commit  string         `yaml:"commit"`
+	Layers  map[string]any `yaml:"layers"`
+}
+
+// manifestRepoRoot returns the top directory of the repo containing the
+// manifest file, or its directory if it's not in a repo.
+func manifestRepoRoot(filePath string) string {
+	dir := filepath.Dir(filePath)
+	for d := dir; ; {
+		if isDir(filepath.Join(d, ".git")) {
+			return d
+		}
+
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+func readYaml(filePath string) (map[string]any, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to open file: %s", err)
+	}
+
+	doc := make(map[string]any)
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Fail to parse yaml: %s", err)
+	}
+
+	return doc, nil
+}
+
+// mergeMaps merges src into dst recursively. Values in src win, except that
+// maps in both are merged.
+func mergeMaps(dst, src map[string]any) {
+	for k, v := range src {
+		srcMap, ok := v.(map[string]any)
+		if !ok {
+			dst[k] = v
+			continue
+		}
+
+		dstMap, ok := dst[k].(map[string]any)
+		if !ok {
+			dstMap = make(map[string]any)
+			dst[k] = dstMap
+		}
+		mergeMaps(dstMap, srcMap)
+	}
+}
+
+// readKasFile reads the kas file with its includes, which are merged in order
+// before the file itself. Includes are relative to the top of the repo.
+func readKasFile(filePath, root string, visited map[string]bool) (map[string]any, error) {
+	if visited[filePath] {
+		return nil, fmt.Errorf("Recursive include of %s", filePath)
+	}
+	visited[filePath] = true
+	defer delete(visited, filePath)
+
+	doc, err := readYaml(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]any)
+	header, _ := doc["header"].(map[string]any)
+	includes, _ := header["includes"].([]any)
+	for _, inc := range includes {
+		name, ok := inc.(string)
+		if !ok {
+			// Includes from other repos need the repos synced first
+			log.Warnf("Skip include of another repo in %s: %v", filePath, inc)
+			continue
+		}
+
+		incDoc, err := readKasFile(filepath.Join(root, name), root, visited)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to include %s: %s", name, err)
+		}
+		mergeMaps(merged, incDoc)
+	}
+	mergeMaps(merged, doc)
+
+	return merged, nil
+}
+
+// revision returns the revision of the repo in the form of repo manifests.
+// A commit wins over a tag, which wins over a branch. Like kas, the default
+// branch of the remote is used if none is given.
+func (r *kasRepo) revision(defaults *kasRepo) string {
+	switch {
+	case r.Commit != "":
+		return r.Commit
+	case r.Tag != "":
+		return "refs/tags/" + r.Tag
+	case r.Branch != "":
+		return r.Branch
+	case r.Refspec != "":
+		return r.Refspec
+	case defaults.Tag != "":
+		return "refs/tags/" + defaults.Tag
+	case defaults.Branch != "":
+		return defaults.Branch
+	case defaults.Refspec != "":
+		return defaults.Refspec
+	}
+
+	return remoteHeadRevision
+}
+
+// layers returns the enabled layers of the repo. The repo itself is the only
+// layer if none is given.
+func (r *kasRepo) layers() []string {
+	if len(r.Layers) == 0 {
+		return []string{"."}
+	}
+
+	var layers []string
+	for name, v := range r.Layers {
+		switch v {
+		case "excluded", "disabled", false:
+			continue
+		}
+		layers = append(layers, name)
+	}
+	sort.Strings(layers)
+
+	return layers
+}
+
+// loadKasManifest maps repos of a kas project file to projects. Repos without
+// URLs are the repo of the kas file itself, so they are skipped.
+func loadKasManifest(filePath string) (*Manifest, error) {
+	doc, err := readKasFile(filePath, manifestRepoRoot(filePath), make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	// The merged document is decoded again into the structs
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to merge kas files: %s", err)
+	}
+
+	var cfg kasConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("Fail to parse kas file: %s", err)
+	}
+
+	names := make([]string, 0, len(cfg.Repos))
+	for name := range cfg.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m := &Manifest{}
+	for _, name := range names {
+		r := cfg.Repos[name]
+		if r == nil || r.Url == "" {
+			continue
+		}
+
+		p := Project{
+			Name:     name,
+			Path:     r.Path,
+			Remote:   urlRemoteName,
+			Revision: r.revision(&cfg.Defaults.Repos),
+			URL:      r.Url,
+			Layers:   r.layers(),
+		}
+		if p.Path == "" {
+			p.Path = name
+		}
+
+		m.Projects = append(m.Projects, p)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+var CmdList = cli.Command{
+	Name:      "list",
+	Usage:     "List projects in the manifest",
+	ArgsUsage: "[projects...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "path-only",
+			Usage:   "Only print paths of projects",
+			Aliases: []string{"p"},
+		},
+		&cli.BoolFlag{
+			Name:    "name-only",
+			Usage:   "Only print names of projects",
+			Aliases: []string{"n"},
+		},
+		&cli.BoolFlag{
+			Name:    "fullpath",
+			Usage:   "Print absolute paths of projects",
+			Aliases: []string{"f"},
+		},
+		&cli.StringFlag{
+			Name:    "regex",
+			Usage:   "Only list projects whose name or path matches the regex",
+			Aliases: []string{"r"},
+		},
+		&cli.StringFlag{
+			Name:    "groups",
+			Usage:   "Only list projects of the groups (comma-separated, '-' prefix to exclude)",
+			Aliases: []string{"g"},
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print projects in JSON format",
+		},
+	},
+	Action: cmdList,
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+type projectEntry struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Remote   string `json:"remote"`
+	Url      string `json:"url"`
+	Revision string `json:"revision"`
+	Resolved string `json:"resolved,omitempty"`
+}
+
+func cmdList(ctx *cli.Context) error {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	projects, err := selectProjects(m, ctx.Args().Slice(), ctx.String("groups"), ctx.String("regex"))
+	if err != nil {
+		return fmt.Errorf("Fail to select projects: %s", err)
+	}
+
+	fullPath := ctx.Bool("fullpath")
+	if ctx.Bool("json") {
+		entries := []projectEntry{}
+		for _, p := range projects {
+			entries = append(entries, newProjectEntry(m, &p, fullPath))
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			return fmt.Errorf("Fail to marshal: %s", err)
+		}
+
+		return nil
+	}
+
+	for _, p := range projects {
+		path := p.Path
+		if fullPath {
+			path = filepath.Join(ProjectRoot, p.Path)
+		}
+
+		switch {
+		case ctx.Bool("path-only"):
+			fmt.Printf("%s\n", path)
+		case ctx.Bool("name-only"):
+			fmt.Printf("%s\n", p.Name)
+		default:
+			fmt.Printf("%s : %s\n", path, p.Name)
+		}
+	}
+
+	return nil
+}
+
+func newProjectEntry(m *Manifest, p *Project, fullPath bool) projectEntry {
+	e := projectEntry{
+		Name: p.Name,
+		Path: p.Path,
+	}
+	if fullPath {
+		e.Path = filepath.Join(ProjectRoot, p.Path)
+	}
+
+	e.Remote, e.Url, _ = m.GetRemote(p)
+	e.Revision, _ = m.GetRevision(p)
+
+	// The revision can be resolved only if the project is synced
+	if _, _, hash, err := getRevs(m, p); err == nil {
+		e.Resolved = hash
+	}
+
+	return e
+}
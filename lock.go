@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type LockMode int
+
+const (
+	// Shared locks are taken by read-only commands, and can be held by
+	// multiple processes at the same time.
+	LockShared LockMode = iota
+	// An exclusive lock is taken by commands modifying the workspace.
+	LockExclusive
+)
+
+const lockPollInterval = 200 * time.Millisecond
+
+// The lock file held by this process
+var (
+	heldLock     *os.File
+	heldLockMode LockMode
+)
+
+func lockPath() string {
+	return filepath.Join(ConfDir, "lock")
+}
+
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// lockHolder returns the PID of the process holding the exclusive lock, which
+// is only used to tell the user. It's 0 if unknown, e.g. the lock is shared.
+func lockHolder(f *os.File) int {
+	content := make([]byte, 32)
+	n, _ := f.ReadAt(content, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content[:n])))
+	if err != nil || pid == os.Getpid() || !isProcessAlive(pid) {
+		return 0
+	}
+
+	return pid
+}
+
+// tryLock makes one attempt to take the lock. The kernel releases the lock
+// when the process dies, so no lock is ever left behind.
+func tryLock(f *os.File, mode LockMode) (bool, error) {
+	how := syscall.LOCK_SH
+	if mode == LockExclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AcquireLock locks the workspace. If it's locked by others, wait for at most
+// the given duration before giving up.
+func AcquireLock(mode LockMode, wait time.Duration) error {
+	f, err := os.OpenFile(lockPath(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("Fail to lock workspace: %s", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		locked, err := tryLock(f, mode)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("Fail to lock workspace: %s", err)
+		}
+
+		if locked {
+			break
+		}
+
+		holder := lockHolder(f)
+		if time.Now().After(deadline) {
+			f.Close()
+
+			buf := bytes.NewBuffer(nil)
+			fmt.Fprintf(buf, "The workspace is locked by another gorepo process")
+			if holder > 0 {
+				fmt.Fprintf(buf, " (pid %d)", holder)
+			}
+			// --wait is a global flag, which comes before the command
+			fmt.Fprintf(buf, ". Use 'gorepo --wait <duration> <command>' to wait for it")
+
+			return errors.New(buf.String())
+		}
+
+		log.Debugf("Workspace is locked by %d. Wait.", holder)
+		time.Sleep(lockPollInterval)
+	}
+
+	// The PID of the exclusive holder is kept for others to tell the user
+	if mode == LockExclusive {
+		if err := f.Truncate(0); err == nil {
+			f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+		}
+	}
+
+	heldLock = f
+	heldLockMode = mode
+	return nil
+}
+
+func ReleaseLock() {
+	if heldLock == nil {
+		return
+	}
+
+	if heldLockMode == LockExclusive {
+		heldLock.Truncate(0)
+	}
+
+	// Closing the file releases the lock
+	heldLock.Close()
+	heldLock = nil
+}
@@ -2,14 +2,47 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime/debug"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
 
+// Exit codes other than the generic failure
+const (
+	ExitSyncFailed  = 2
+	ExitInterrupted = 130
+
+	// Like git grep
+	ExitGrepNoMatch = 1
+	ExitGrepFailed  = 2
+)
+
+// exitError makes the process exit with the specific code. It intentionally
+// doesn't implement cli.ExitCoder, which exits before After hooks are run.
+// Nothing is printed if err is nil.
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
 func cmdVersion(ctx *cli.Context) error {
 	info, ok := debug.ReadBuildInfo()
 	if !ok {
@@ -63,6 +96,14 @@ func main() {
 			&CmdSync,
 			&CmdStatus,
 			&CmdInfo,
+			&CmdForall,
+			&CmdDiff,
+			&CmdGrep,
+			&CmdList,
+			&CmdPrune,
+			&CmdOverview,
+			&CmdYocto,
+			&CmdManifest,
 			&CmdVersion,
 		},
 		Flags: []cli.Flag{
@@ -77,10 +118,33 @@ func main() {
 					return nil
 				},
 			},
+			&cli.DurationFlag{
+				Name:  "wait",
+				Usage: "How long to wait if the workspace is locked by another gorepo process",
+				Value: 0,
+			},
 		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	// Commands are cancelled gracefully on the first signal. The default
+	// behavior is restored afterwards, so that a second one terminates the
+	// process immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := app.RunContext(ctx, os.Args)
+	stop()
+	if err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			if exitErr.err != nil {
+				log.Error(err)
+			}
+			os.Exit(exitErr.code)
+		}
 		log.Fatal(err)
 	}
 }
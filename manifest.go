@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
@@ -13,6 +14,9 @@ type Manifest struct {
 	Defaults Default   `xml:"default"`
 	Remotes  []Remote  `xml:"remote"`
 	Projects []Project `xml:"project"`
+	// Some projects may be missing, e.g. imports of west projects which are
+	// not synced yet
+	Incomplete bool `xml:"-"`
 }
 
 type Remote struct {
@@ -28,12 +32,26 @@ type Default struct {
 }
 
 type Project struct {
-	Name      string     `xml:"name,attr"`
-	Path      string     `xml:"path,attr"`
-	Remote    string     `xml:"remote,attr"`
-	Revision  string     `xml:"revision,attr"`
-	Copyfiles []Copyfile `xml:"copyfile"`
-	Linkfiles []Linkfile `xml:"linkfile"`
+	Name        string       `xml:"name,attr"`
+	Path        string       `xml:"path,attr"`
+	Remote      string       `xml:"remote,attr"`
+	Revision    string       `xml:"revision,attr"`
+	Groups      string       `xml:"groups,attr"`
+	Copyfiles   []Copyfile   `xml:"copyfile"`
+	Linkfiles   []Linkfile   `xml:"linkfile"`
+	Annotations []Annotation `xml:"annotation"`
+
+	// Only set by manifests in other formats, like kas
+	URL    string   `xml:"-"`
+	Layers []string `xml:"-"`
+	// Bare revisions name tags before branches, as in west
+	TagFirst bool `xml:"-"`
+}
+
+type Annotation struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+	Keep  string `xml:"keep,attr,omitempty"`
 }
 
 type Linkfile struct {
@@ -46,7 +64,14 @@ type Copyfile struct {
 	Dest string `xml:"dest,attr"`
 }
 
+// LoadManifest reads the manifest of repo, or YAML files of other tools which
+// are told by the extension of the file.
 func LoadManifest(filePath string) (manifest *Manifest, err error) {
+	switch filepath.Ext(filePath) {
+	case ".yml", ".yaml":
+		return loadYamlManifest(filePath)
+	}
+
 	f, err := os.Open(filePath)
 	if err != nil {
 		err = fmt.Errorf("Fail to open file: %s", err)
@@ -68,6 +93,22 @@ func LoadManifest(filePath string) (manifest *Manifest, err error) {
 	return
 }
 
+func loadYamlManifest(filePath string) (*Manifest, error) {
+	doc, err := readYaml(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := doc["header"]; ok {
+		return loadKasManifest(filePath)
+	}
+	if _, ok := doc["manifest"]; ok {
+		return loadWestManifest(filePath)
+	}
+
+	return nil, fmt.Errorf("Unknown format of yaml manifest")
+}
+
 func (m *Manifest) GetSyncJ() (int, error) {
 	str := m.Defaults.SyncJ
 	if str == "" {
@@ -95,6 +136,10 @@ func (m *Manifest) GetRevision(p *Project) (string, error) {
 }
 
 func (m *Manifest) GetRemote(p *Project) (string, string, error) {
+	if p.URL != "" {
+		return urlRemoteName, p.URL, nil
+	}
+
 	remoteName := p.Remote
 	if remoteName == "" {
 		remoteName = m.Defaults.Remote
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var CmdOverview = cli.Command{
+	Name:      "overview",
+	Usage:     "List local commits which are not in 'manifest-rev'",
+	ArgsUsage: "[projects...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "current-branch",
+			Usage:   "Only consider checked-out branches",
+			Aliases: []string{"c"},
+		},
+		&cli.StringFlag{
+			Name:    "groups",
+			Usage:   "Only list projects of the groups (comma-separated, '-' prefix to exclude)",
+			Aliases: []string{"g"},
+		},
+		&cli.StringFlag{
+			Name:    "regex",
+			Usage:   "Only list projects whose name or path matches the regex",
+			Aliases: []string{"r"},
+		},
+	},
+	Action: cmdOverview,
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+func cmdOverview(ctx *cli.Context) error {
+	olog := log.WithFields(log.Fields{
+		"cmd": "overview",
+	})
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	projects, err := selectProjects(m, ctx.Args().Slice(), ctx.String("groups"), ctx.String("regex"))
+	if err != nil {
+		return fmt.Errorf("Fail to select projects: %s", err)
+	}
+
+	currentOnly := ctx.Bool("current-branch")
+
+	// Results are buffered, so that they are printed in manifest order
+	results := make([]*bytes.Buffer, len(projects))
+	parallelDo(runtime.NumCPU(), len(projects), func(i int) {
+		p := projects[i]
+		results[i] = bytes.NewBuffer(nil)
+		err := overviewRepo(results[i], p.Path, currentOnly)
+		if err != nil {
+			olog.WithFields(log.Fields{
+				"project": p.Path,
+			}).Errorf("Fail to list commits: %s", err)
+		}
+	})
+
+	for _, r := range results {
+		os.Stdout.Write(r.Bytes())
+	}
+
+	return nil
+}
+
+// overviewRepo writes local branches with commits not in 'manifest-rev' to w.
+// Nothing is written if there are no such branches.
+func overviewRepo(w io.Writer, relPath string, currentOnly bool) error {
+	repoPath := filepath.Join(ProjectRoot, relPath)
+	if !isDir(repoPath) {
+		return nil
+	}
+
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("Fail to open repo: %s", err)
+	}
+
+	manifestRef, err := findBranch(repo, "manifest-rev")
+	if err != nil {
+		return err
+	}
+
+	headRef, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return fmt.Errorf("Fail to read HEAD: %s", err)
+	}
+	current := plumbing.ReferenceName("")
+	if headRef.Type() == plumbing.SymbolicReference {
+		current = headRef.Target()
+	}
+
+	branches, err := repo.Branches()
+	if err != nil {
+		return fmt.Errorf("Fail to list branches: %s", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = branches.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() == manifestRef.Name() {
+			return nil
+		}
+		if currentOnly && ref.Name() != current {
+			return nil
+		}
+
+		commits, _, err := uniqueCommits(repo, ref.Hash(), manifestRef.Hash())
+		if err != nil {
+			return err
+		}
+		if len(commits) == 0 {
+			return nil
+		}
+
+		mark := " "
+		if ref.Name() == current {
+			mark = "*"
+		}
+		fmt.Fprintf(buf, "%s %-30s (%d commit(s))\n", mark, ref.Name().Short(), len(commits))
+		for _, c := range commits {
+			subject, _, _ := strings.Cut(c.Message, "\n")
+			fmt.Fprintf(buf, "      %s %s %s (%s)\n", c.Hash.String()[:7],
+				c.Author.When.Local().Format("2006-01-02"), subject, c.Author.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if buf.Len() > 0 {
+		fmt.Fprintf(w, "project %s/\n", relPath)
+		w.Write(buf.Bytes())
+	}
+
+	return nil
+}
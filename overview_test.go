@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestOverviewRepo(t *testing.T) {
+	oldRoot := ProjectRoot
+	ProjectRoot = t.TempDir()
+	defer func() { ProjectRoot = oldRoot }()
+
+	repo, err := git.PlainInit(filepath.Join(ProjectRoot, "proj"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All commits are made in the same second
+	h := &testHistory{t: t, repo: repo, when: time.Unix(1700000000, 0)}
+	base := h.chain(plumbing.ZeroHash, 10)
+	upstream := h.chain(base, 1)
+	local := h.chain(base, 2)
+
+	for name, hash := range map[string]plumbing.Hash{
+		"manifest-rev": upstream,
+		"behind":       base,
+		"feature":      local,
+	} {
+		ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), hash)
+		if err := repo.Storer.SetReference(ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("behind"))
+	if err := repo.Storer.SetReference(head); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := overviewRepo(buf, "proj", false); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "behind") {
+		t.Errorf("branch behind manifest-rev is listed:\n%s", out)
+	}
+	if !strings.Contains(out, "feature") || !strings.Contains(out, "(2 commit(s))") {
+		t.Errorf("commits of feature are not listed:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := overviewRepo(buf, "proj", true); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("current branch behind manifest-rev is listed:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Phases of a sync job shown in the progress
+const (
+	PhaseFetch    = "fetch"
+	PhaseCheckout = "checkout"
+	PhaseCopyfile = "copyfile"
+)
+
+const (
+	progressInterval = 100 * time.Millisecond
+	progressPathLen  = 32
+	progressInfoLen  = 40
+)
+
+type workerSlot struct {
+	path  string
+	phase string
+	info  string
+	start time.Time
+}
+
+// progress renders the progress of sync jobs. On a terminal, there is a line
+// for each active worker and an overall counter, which are redrawn in place,
+// and log messages are printed above them. Otherwise, only a line is printed
+// when a job starts or finishes.
+type progress struct {
+	mutex  sync.Mutex
+	out    io.Writer
+	tty    bool
+	quiet  bool
+	total  int
+	done   int
+	failed int
+	start  time.Time
+	slots  []workerSlot
+	lines  int
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// jobProgress reports the progress of the job run by a worker. It's also the
+// writer of sideband messages from the remote.
+type jobProgress struct {
+	p   *progress
+	idx int
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func newProgress(total, workers int, quiet bool) *progress {
+	return &progress{
+		out:    os.Stdout,
+		tty:    isTerminal(os.Stdout),
+		quiet:  quiet,
+		total:  total,
+		slots:  make([]workerSlot, workers),
+		stopCh: make(chan struct{}),
+	}
+}
+
+func (p *progress) Start() {
+	p.start = time.Now()
+	if p.quiet || !p.tty {
+		return
+	}
+
+	// Log messages keep colors, though they don't go to the terminal directly.
+	// It's not restored, since the formatter checks the output only once.
+	if f, ok := log.StandardLogger().Formatter.(*log.TextFormatter); ok && isTerminal(os.Stderr) {
+		f.ForceColors = true
+	}
+	log.SetOutput(p)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.mutex.Lock()
+				p.redraw()
+				p.mutex.Unlock()
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops redrawing and clears the progress. It's safe to call it more
+// than once.
+func (p *progress) Stop() {
+	select {
+	case <-p.stopCh:
+		return
+	default:
+	}
+
+	close(p.stopCh)
+	p.wg.Wait()
+
+	if p.quiet {
+		return
+	}
+
+	if p.tty {
+		p.mutex.Lock()
+		p.clear()
+		p.mutex.Unlock()
+		log.SetOutput(os.Stderr)
+	}
+
+	fmt.Fprintf(p.out, "Synced %d/%d project(s)", p.done-p.failed, p.total)
+	if p.failed > 0 {
+		fmt.Fprintf(p.out, ", %d failed", p.failed)
+	}
+	fmt.Fprintf(p.out, " in %s\n", time.Since(p.start).Round(time.Second))
+}
+
+// Write prints log messages above the progress lines.
+func (p *progress) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.clear()
+	n, err := os.Stderr.Write(b)
+	p.redraw()
+
+	return n, err
+}
+
+func (p *progress) Job(idx int) *jobProgress {
+	return &jobProgress{p: p, idx: idx}
+}
+
+func (p *progress) begin(idx int, path string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.slots[idx] = workerSlot{
+		path:  path,
+		start: time.Now(),
+	}
+
+	if !p.quiet && !p.tty {
+		fmt.Fprintf(p.out, "Syncing %s\n", path)
+	}
+}
+
+func (p *progress) finish(idx int, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s := p.slots[idx]
+	p.slots[idx] = workerSlot{}
+	p.done++
+	if err != nil {
+		p.failed++
+	}
+
+	if p.quiet || p.tty {
+		return
+	}
+
+	result := "done"
+	if err != nil {
+		result = "failed"
+	}
+	fmt.Fprintf(p.out, "[%d/%d] %s %s (%s)\n", p.done, p.total, s.path, result,
+		time.Since(s.start).Round(time.Second))
+}
+
+func (p *progress) setPhase(idx int, phase string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.slots[idx].phase = phase
+	p.slots[idx].info = ""
+}
+
+func (p *progress) setInfo(idx int, info string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.slots[idx].info = info
+}
+
+// clear erases the lines drawn last time. The cursor is left where the first
+// line was.
+func (p *progress) clear() {
+	if p.lines == 0 {
+		return
+	}
+
+	fmt.Fprintf(p.out, "\033[%dA\033[J", p.lines)
+	p.lines = 0
+}
+
+func (p *progress) redraw() {
+	select {
+	case <-p.stopCh:
+		return
+	default:
+	}
+
+	// Lines are cut to the width, since wrapped lines would break moving the
+	// cursor up by the number of lines. The last column is left for terminals
+	// wrapping as soon as it's written.
+	width := terminalWidth(p.out) - 1
+	buf := bytes.NewBuffer(nil)
+	lines := 0
+	for _, s := range p.slots {
+		if s.path == "" {
+			continue
+		}
+
+		line := fmt.Sprintf("  %-*s %-8s %s", progressPathLen, truncate(s.path, progressPathLen),
+			s.phase, truncate(s.info, progressInfoLen))
+		fmt.Fprintf(buf, "\033[2K%s\n", truncate(line, width))
+		lines++
+	}
+
+	line := fmt.Sprintf("Syncing: %d/%d", p.done, p.total)
+	if p.failed > 0 {
+		line += fmt.Sprintf(", %d failed", p.failed)
+	}
+	line += fmt.Sprintf(", elapsed %s", time.Since(p.start).Round(time.Second))
+	if eta, ok := p.eta(); ok {
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprintf(buf, "\033[2K%s\n", truncate(line, width))
+	lines++
+
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\033[%dA", p.lines)
+	}
+	p.out.Write(buf.Bytes())
+	// Leftovers of longer progress drawn last time
+	if lines < p.lines {
+		fmt.Fprintf(p.out, "\033[J")
+	}
+	p.lines = lines
+}
+
+// eta estimates the remaining time from the average duration of finished
+// jobs.
+func (p *progress) eta() (time.Duration, bool) {
+	if p.done == 0 || p.done >= p.total {
+		return 0, false
+	}
+
+	avg := time.Since(p.start) / time.Duration(p.done)
+	return avg * time.Duration(p.total-p.done), true
+}
+
+// terminalWidth returns the number of columns of the terminal, or 80 if it's
+// unknown.
+func terminalWidth(w io.Writer) int {
+	f, ok := w.(*os.File)
+	if !ok {
+		return 80
+	}
+
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 80
+	}
+
+	return int(ws.Col)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(r[:max(n, 0)])
+	}
+
+	return string(r[:n-3]) + "..."
+}
+
+func (j *jobProgress) Begin(path string) {
+	j.p.begin(j.idx, path)
+}
+
+func (j *jobProgress) Finish(err error) {
+	j.p.finish(j.idx, err)
+}
+
+func (j *jobProgress) SetPhase(phase string) {
+	j.p.setPhase(j.idx, phase)
+}
+
+// Write takes sideband messages, like "Receiving objects:  45% (9/20)". Only
+// the latest one is shown, and they are never printed on a non-terminal.
+func (j *jobProgress) Write(b []byte) (int, error) {
+	msg := strings.TrimRight(string(b), "\r\n")
+	if i := strings.LastIndexAny(msg, "\r\n"); i >= 0 {
+		msg = msg[i+1:]
+	}
+	msg = strings.TrimSpace(msg)
+	if msg != "" {
+		j.p.setInfo(j.idx, msg)
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+func splitGroups(str string) []string {
+	return strings.FieldsFunc(str, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// GetGroups returns the groups the project belongs to, including the implicit
+// ones defined by repo: 'all', 'name:<name>', 'path:<path>' and 'default'
+// unless the project is marked as 'notdefault'.
+func (p *Project) GetGroups() []string {
+	groups := splitGroups(p.Groups)
+	groups = append(groups, "all", "name:"+p.Name, "path:"+p.Path)
+
+	isDefault := true
+	for _, g := range groups {
+		if g == "notdefault" {
+			isDefault = false
+		}
+	}
+	if isDefault {
+		groups = append(groups, "default")
+	}
+
+	return groups
+}
+
+// GetAnnotation returns the value of the annotation with the name.
+func (p *Project) GetAnnotation(name string) (string, bool) {
+	for _, a := range p.Annotations {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// InGroups checks whether the project matches the group filter. A group
+// prefixed with '-' excludes the projects belonging to it.
+func (p *Project) InGroups(filter []string) bool {
+	groups := make(map[string]bool)
+	for _, g := range p.GetGroups() {
+		groups[g] = true
+	}
+
+	hasInclude := false
+	included := false
+	for _, f := range filter {
+		if strings.HasPrefix(f, "-") {
+			if groups[f[1:]] {
+				return false
+			}
+			continue
+		}
+
+		hasInclude = true
+		if groups[f] {
+			included = true
+		}
+	}
+
+	return included || !hasInclude
+}
+
+// findProject looks up a project by its name, its path relative to the
+// project root, or a filesystem path (relative to the current directory)
+// pointing inside the project.
+func findProject(m *Manifest, arg string) (int, error) {
+	for i, p := range m.Projects {
+		if p.Name == arg {
+			return i, nil
+		}
+	}
+
+	cleaned := filepath.Clean(arg)
+	for i, p := range m.Projects {
+		if filepath.Clean(p.Path) == cleaned {
+			return i, nil
+		}
+	}
+
+	absPath, err := filepath.Abs(arg)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := os.Stat(absPath); err != nil {
+		return -1, fmt.Errorf("Project not found: %s", arg)
+	}
+	relPath, err := filepath.Rel(ProjectRoot, absPath)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return -1, fmt.Errorf("Project not found: %s", arg)
+	}
+
+	// Pick the innermost project in case of nested projects
+	found := -1
+	foundLen := 0
+	for i, p := range m.Projects {
+		prjPath := filepath.Clean(p.Path)
+		if relPath != prjPath && !strings.HasPrefix(relPath, prjPath+string(os.PathSeparator)) {
+			continue
+		}
+		if len(prjPath) > foundLen {
+			found = i
+			foundLen = len(prjPath)
+		}
+	}
+
+	if found < 0 {
+		return -1, fmt.Errorf("Project not found: %s", arg)
+	}
+
+	return found, nil
+}
+
+// selectProjects returns the projects specified by args, filtered by groups
+// and by a regex matched against project names and paths. If no args are
+// given, all projects in the manifest are candidates. The manifest order is
+// kept.
+func selectProjects(m *Manifest, args []string, groups, pattern string) ([]Project, error) {
+	selected := make([]bool, len(m.Projects))
+	if len(args) == 0 {
+		for i := range selected {
+			selected[i] = true
+		}
+	}
+
+	for _, arg := range args {
+		i, err := findProject(m, arg)
+		if err != nil {
+			return nil, err
+		}
+		selected[i] = true
+	}
+
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regex: %s", err)
+		}
+	}
+
+	groupFilter := splitGroups(groups)
+
+	var out []Project
+	for i, p := range m.Projects {
+		if !selected[i] {
+			continue
+		}
+
+		if !p.InGroups(groupFilter) {
+			continue
+		}
+
+		if re != nil && !re.MatchString(p.Name) && !re.MatchString(p.Path) {
+			continue
+		}
+
+		out = append(out, p)
+	}
+
+	return out, nil
+}
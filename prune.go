@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var CmdPrune = cli.Command{
+	Name:      "prune",
+	Usage:     "Delete local branches merged into 'manifest-rev'",
+	ArgsUsage: "[projects...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "dry-run",
+			Usage:   "Only list branches which would be deleted",
+			Aliases: []string{"n"},
+		},
+		&cli.StringFlag{
+			Name:    "groups",
+			Usage:   "Only prune projects of the groups (comma-separated, '-' prefix to exclude)",
+			Aliases: []string{"g"},
+		},
+		&cli.StringFlag{
+			Name:    "regex",
+			Usage:   "Only prune projects whose name or path matches the regex",
+			Aliases: []string{"r"},
+		},
+	},
+	Action: cmdPrune,
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		// Nothing is changed in dry-run mode
+		mode := LockExclusive
+		if c.Bool("dry-run") {
+			mode = LockShared
+		}
+		return AcquireLock(mode, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+func cmdPrune(ctx *cli.Context) error {
+	plog := log.WithFields(log.Fields{
+		"cmd": "prune",
+	})
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	projects, err := selectProjects(m, ctx.Args().Slice(), ctx.String("groups"), ctx.String("regex"))
+	if err != nil {
+		return fmt.Errorf("Fail to select projects: %s", err)
+	}
+
+	dryRun := ctx.Bool("dry-run")
+	failed := 0
+	for _, p := range projects {
+		err := pruneRepo(p.Path, dryRun)
+		if err != nil {
+			plog.WithFields(log.Fields{
+				"project": p.Path,
+			}).Errorf("Fail to prune: %s", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Fail to prune %d project(s)", failed)
+	}
+
+	return nil
+}
+
+// classifyBranches splits local branches into the ones whose tips are
+// contained in 'manifest-rev' and the ones which are not. 'manifest-rev' itself
+// is excluded.
+func classifyBranches(repo *git.Repository) (merged, unmerged []*plumbing.Reference, err error) {
+	manifestRef, err := findBranch(repo, "manifest-rev")
+	if err != nil {
+		return
+	}
+
+	manifestCommit, err := repo.CommitObject(manifestRef.Hash())
+	if err != nil {
+		err = fmt.Errorf("Fail to read commit: %s", err)
+		return
+	}
+
+	branches, err := repo.Branches()
+	if err != nil {
+		err = fmt.Errorf("Fail to list branches: %s", err)
+		return
+	}
+
+	err = branches.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() == manifestRef.Name() {
+			return nil
+		}
+
+		c, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("Fail to read commit of %s: %s", ref.Name().Short(), err)
+		}
+
+		isMerged, err := c.IsAncestor(manifestCommit)
+		if err != nil {
+			return err
+		}
+
+		if isMerged {
+			merged = append(merged, ref)
+		} else {
+			unmerged = append(unmerged, ref)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+func pruneRepo(relPath string, dryRun bool) error {
+	repoPath := filepath.Join(ProjectRoot, relPath)
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("Fail to open repo: %s", err)
+	}
+
+	merged, _, err := classifyBranches(repo)
+	if err != nil {
+		return err
+	}
+
+	// The checked-out branch is kept
+	headRef, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return fmt.Errorf("Fail to read HEAD: %s", err)
+	}
+
+	for _, ref := range merged {
+		name := ref.Name().Short()
+		if headRef.Type() == plumbing.SymbolicReference && headRef.Target() == ref.Name() {
+			fmt.Printf("%s: skip checked-out branch %s\n", relPath, name)
+			continue
+		}
+
+		if dryRun {
+			fmt.Printf("%s: would delete branch %s\n", relPath, name)
+			continue
+		}
+
+		err := repo.Storer.RemoveReference(ref.Name())
+		if err != nil {
+			return fmt.Errorf("Fail to delete branch %s: %s", name, err)
+		}
+		// Not every branch has a config section, so the error is ignored
+		repo.DeleteBranch(name)
+
+		fmt.Printf("%s: deleted branch %s\n", relPath, name)
+	}
+
+	return nil
+}
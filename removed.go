@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkRemovable makes sure nothing is lost if the repo is deleted, i.e. the
+// worktree is clean, and every local branch and HEAD are contained in
+// 'manifest-rev'.
+func checkRemovable(repoPath string) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("Fail to open repo: %s", err)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("Fail to get worktree: %s", err)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return fmt.Errorf("Fail to get worktree status: %s", err)
+	}
+	if !status.IsClean() {
+		return fmt.Errorf("it has uncommitted changes")
+	}
+
+	_, unmerged, err := classifyBranches(repo)
+	if err != nil {
+		return err
+	}
+	if len(unmerged) > 0 {
+		var names []string
+		for _, ref := range unmerged {
+			names = append(names, ref.Name().Short())
+		}
+		return fmt.Errorf("it has branches not in 'manifest-rev': %s", strings.Join(names, ", "))
+	}
+
+	headRef, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("Fail to resolve HEAD: %s", err)
+	}
+	manifestRef, err := findBranch(repo, "manifest-rev")
+	if err != nil {
+		return err
+	}
+
+	headCommit, err := repo.CommitObject(headRef.Hash())
+	if err != nil {
+		return fmt.Errorf("Fail to read commit: %s", err)
+	}
+	manifestCommit, err := repo.CommitObject(manifestRef.Hash())
+	if err != nil {
+		return fmt.Errorf("Fail to read commit: %s", err)
+	}
+
+	isMerged, err := headCommit.IsAncestor(manifestCommit)
+	if err != nil {
+		return err
+	}
+	if !isMerged {
+		return fmt.Errorf("its HEAD is not in 'manifest-rev'")
+	}
+
+	return nil
+}
+
+// removeEmptyParents deletes empty parent directories of the path up to the
+// project root.
+func removeEmptyParents(path string) {
+	dir := filepath.Dir(path)
+	for dir != ProjectRoot && strings.HasPrefix(dir, ProjectRoot) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// updateProjectList compares projects in the manifest with the ones recorded
+// at the last sync, and deletes worktrees of projects which are removed from
+// the manifest, unless keepRemoved is set. Projects which can't be deleted
+// safely are kept, and remain in the list so that they are checked again next
+// time.
+func updateProjectList(m *Manifest, keepRemoved bool) error {
+	rlog := log.WithFields(log.Fields{
+		"cmd":   "sync",
+		"stage": "remove-projects",
+	})
+
+	oldPaths, err := LoadProjectList()
+	if err != nil {
+		return fmt.Errorf("Fail to load project list: %s", err)
+	}
+
+	current := make(map[string]bool)
+	var newPaths []string
+	for _, p := range m.Projects {
+		path := filepath.Clean(p.Path)
+		if !current[path] {
+			current[path] = true
+			newPaths = append(newPaths, path)
+		}
+	}
+
+	var refused []string
+	for _, path := range oldPaths {
+		if current[path] {
+			continue
+		}
+
+		repoPath := filepath.Join(ProjectRoot, path)
+		if !isDir(repoPath) {
+			continue
+		}
+
+		if keepRemoved {
+			rlog.Infof("Keep %s removed from the manifest", path)
+			newPaths = append(newPaths, path)
+			continue
+		}
+
+		nested := false
+		for p := range current {
+			if strings.HasPrefix(p, path+string(os.PathSeparator)) {
+				nested = true
+			}
+		}
+		if nested {
+			rlog.Warnf("Keep %s since it contains other projects", path)
+			newPaths = append(newPaths, path)
+			continue
+		}
+
+		if err := checkRemovable(repoPath); err != nil {
+			refused = append(refused, fmt.Sprintf("%s (%s)", path, err))
+			newPaths = append(newPaths, path)
+			continue
+		}
+
+		rlog.Infof("Delete %s removed from the manifest", path)
+		if err := os.RemoveAll(repoPath); err != nil {
+			refused = append(refused, fmt.Sprintf("%s (%s)", path, err))
+			newPaths = append(newPaths, path)
+			continue
+		}
+		removeEmptyParents(repoPath)
+	}
+
+	if err := SaveProjectList(newPaths); err != nil {
+		return fmt.Errorf("Fail to save project list: %s", err)
+	}
+
+	if len(refused) > 0 {
+		buf := bytes.NewBuffer(nil)
+		fmt.Fprintf(buf, "Projects removed from the manifest are not deleted: %s. ", strings.Join(refused, "; "))
+		fmt.Fprintf(buf, "Save the changes and delete them manually, or use --keep-removed.")
+
+		return errors.New(buf.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateProjectListNested(t *testing.T) {
+	oldRoot, oldConf := ProjectRoot, ConfDir
+	defer func() { ProjectRoot, ConfDir = oldRoot, oldConf }()
+	ProjectRoot = t.TempDir()
+	ConfDir = filepath.Join(ProjectRoot, ".gorepo")
+
+	for _, dir := range []string{ConfDir, filepath.Join(ProjectRoot, "outer", "inner")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveProjectList([]string{"outer", "outer/inner"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The removed project is kept for the nested one, and stays in the list
+	m := &Manifest{Projects: []Project{{Name: "inner", Path: "outer/inner"}}}
+	if err := updateProjectList(m, false); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := LoadProjectList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer/inner", "outer"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+
+	// It's checked again once the nested project is gone. It's not a repo, so
+	// it's refused.
+	if err := os.RemoveAll(filepath.Join(ProjectRoot, "outer", "inner")); err != nil {
+		t.Fatal(err)
+	}
+	err = updateProjectList(&Manifest{}, false)
+	if err == nil || !strings.Contains(err.Error(), "outer") {
+		t.Errorf("outer is not checked again: %v", err)
+	}
+}
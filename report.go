@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Actions taken for projects in a sync
+const (
+	ActionClone    = "clone"
+	ActionFetch    = "fetch"
+	ActionUpToDate = "up-to-date"
+	ActionSkipped  = "skipped"
+	ActionRecloned = "recloned"
+)
+
+type syncReport struct {
+	Time     time.Time       `json:"time"`
+	Duration float64         `json:"duration"`
+	Success  bool            `json:"success"`
+	Projects []projectReport `json:"projects"`
+}
+
+type projectReport struct {
+	Path     string  `json:"path"`
+	Name     string  `json:"name"`
+	Url      string  `json:"url"`
+	Revision string  `json:"revision"`
+	Hash     string  `json:"hash"`
+	Action   string  `json:"action"`
+	Duration float64 `json:"duration"`
+	Fetched  int64   `json:"fetched"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+func isReportFormat(format string) bool {
+	switch format {
+	case "json", "junit":
+		return true
+	}
+
+	return false
+}
+
+// reportJobs returns jobs in manifest order with their results. Jobs which
+// are never started, e.g. after cancellation, are reported as skipped.
+func reportJobs(m *Manifest, jobs, results []syncJob) []syncJob {
+	finished := make(map[string]syncJob)
+	for _, j := range jobs {
+		finished[j.path] = j
+	}
+	for _, j := range results {
+		finished[j.path] = j
+	}
+
+	var all []syncJob
+	for _, p := range m.Projects {
+		j, ok := finished[p.Path]
+		if !ok {
+			continue
+		}
+		if j.start.IsZero() {
+			j.action = ActionSkipped
+		}
+		all = append(all, j)
+	}
+
+	return all
+}
+
+func newProjectReport(j syncJob) projectReport {
+	r := projectReport{
+		Path:     j.path,
+		Name:     j.name,
+		Url:      j.repo,
+		Revision: j.revision,
+		Hash:     j.hash,
+		Action:   j.action,
+		Duration: j.dur.Seconds(),
+		Fetched:  j.fetched,
+	}
+	if j.err != nil {
+		r.Error = j.err.Error()
+	}
+
+	return r
+}
+
+func writeReport(file, format string, jobs []syncJob, start time.Time, success bool) error {
+	report := syncReport{
+		Time:     start,
+		Duration: time.Since(start).Seconds(),
+		Success:  success,
+		Projects: []projectReport{},
+	}
+	for _, j := range jobs {
+		report.Projects = append(report.Projects, newProjectReport(j))
+	}
+
+	var data []byte
+	var err error
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(report, "", "  ")
+	case "junit":
+		data, err = xml.MarshalIndent(junitReport(report), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	default:
+		return fmt.Errorf("Unknown report format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("Fail to marshal: %s", err)
+	}
+	data = append(data, '\n')
+
+	if dir := filepath.Dir(file); !isDir(dir) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("Fail to create dir: %s", err)
+		}
+	}
+
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return fmt.Errorf("Fail to write file: %s", err)
+	}
+
+	return nil
+}
+
+// junitReport maps each project to a test case, so that failed projects are
+// shown as failed tests by CI.
+func junitReport(report syncReport) junitTestSuites {
+	suite := junitTestSuite{
+		Name:      "gorepo sync",
+		Tests:     len(report.Projects),
+		Time:      fmt.Sprintf("%.3f", report.Duration),
+		Timestamp: report.Time.Format(time.RFC3339),
+	}
+
+	for _, p := range report.Projects {
+		c := junitTestCase{
+			Name:      p.Path,
+			Classname: "sync",
+			Time:      fmt.Sprintf("%.3f", p.Duration),
+			SystemOut: &junitOutput{
+				Text: fmt.Sprintf("name: %s\nurl: %s\nrevision: %s\nhash: %s\naction: %s\nfetched: %d\n",
+					p.Name, p.Url, p.Revision, p.Hash, p.Action, p.Fetched),
+			},
+		}
+
+		switch {
+		case p.Error != "":
+			c.Failure = &junitMessage{Message: p.Error}
+			suite.Failures++
+		case p.Action == ActionSkipped:
+			c.Skipped = &junitMessage{Message: "Not synced"}
+			suite.Skipped++
+		}
+
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	return junitTestSuites{Suites: []junitTestSuite{suite}}
+}
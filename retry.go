@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// Messages of errors which are only available as strings, e.g. from ssh
+var retryableMessages = []string{
+	"connection reset",
+	"connection refused",
+	"connection timed out",
+	"broken pipe",
+	"unexpected eof",
+	"i/o timeout",
+	"tls handshake timeout",
+	"no route to host",
+	"temporary failure in name resolution",
+}
+
+// unwrapGitError returns the error wrapped by go-git's error types, which
+// don't support errors.Unwrap.
+func unwrapGitError(err error) error {
+	var unexpected *plumbing.UnexpectedError
+	if errors.As(err, &unexpected) {
+		return unexpected.Err
+	}
+
+	var permanent *plumbing.PermanentError
+	if errors.As(err, &permanent) {
+		return permanent.Err
+	}
+
+	return nil
+}
+
+// isRetryable tells whether a fetch error is likely to be transient, like
+// network failures, timeouts and server errors, as opposed to errors which
+// don't go away by retrying, like authentication failures and missing refs.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		errors.Is(err, transport.ErrInvalidAuthMethod) ||
+		errors.Is(err, transport.ErrRepositoryNotFound) ||
+		errors.Is(err, transport.ErrEmptyRemoteRepository) ||
+		errors.Is(err, git.NoErrAlreadyUpToDate) ||
+		errors.Is(err, git.NoMatchingRefSpecError{}) ||
+		errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return false
+	}
+
+	var httpErr *githttp.Err
+	if errors.As(err, &httpErr) {
+		code := httpErr.StatusCode()
+		return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests ||
+			code == http.StatusRequestTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	if inner := unwrapGitError(err); inner != nil {
+		return isRetryable(inner)
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range retryableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryDelay returns the delay before the given retry, which is doubled each
+// time up to a limit, with jitter so that parallel jobs don't retry at once.
+func retryDelay(retry int) time.Duration {
+	d := retryBaseDelay << retry
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// fetchWithRetry fetches with the options, and retries transient failures at
+// most maxRetries times. The number of retries is returned.
+func fetchWithRetry(ctx context.Context, repo *git.Repository, o *git.FetchOptions, maxRetries int, flog *log.Entry) (int, error) {
+	retries := 0
+	for {
+		err := repo.FetchContext(ctx, o)
+		if err == nil || retries >= maxRetries || ctx.Err() != nil || !isRetryable(err) {
+			return retries, err
+		}
+
+		d := retryDelay(retries)
+		retries++
+		flog.Warnf("Fetch failed: %s. Retry %d/%d in %s", err, retries, maxRetries, d.Round(time.Millisecond))
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return retries, ctx.Err()
+		}
+	}
+}
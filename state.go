@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	OutcomeOK      = "ok"
+	OutcomeFailed  = "failed"
+	OutcomeSkipped = "skipped"
+)
+
+// State records the result of the last sync.
+type State struct {
+	Sync     SyncState      `toml:"sync"`
+	Projects []ProjectState `toml:"projects"`
+}
+
+type SyncState struct {
+	Time         time.Time `toml:"time"`
+	Duration     float64   `toml:"duration"`
+	Success      bool      `toml:"success"`
+	LastSuccess  time.Time `toml:"last-success"`
+	ManifestRev  string    `toml:"manifest-rev"`
+	ManifestHash string    `toml:"manifest-hash"`
+}
+
+type ProjectState struct {
+	Path     string    `toml:"path"`
+	Name     string    `toml:"name"`
+	Url      string    `toml:"url"`
+	Revision string    `toml:"revision"`
+	Hash     string    `toml:"hash"`
+	Time     time.Time `toml:"time"`
+	Duration float64   `toml:"duration"`
+	Outcome  string    `toml:"outcome"`
+	Error    string    `toml:"error,omitempty"`
+}
+
+// SaveState writes the state to a temporary file first, and renames it, so
+// that the state file is never left half-written.
+func SaveState(st *State) error {
+	stateFile := filepath.Join(ConfDir, "state")
+	tmpFile := stateFile + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("Fail to create file: %s", err)
+	}
+	defer os.Remove(tmpFile)
+	defer f.Close()
+
+	encoder := toml.NewEncoder(f)
+	err = encoder.Encode(st)
+	if err != nil {
+		return fmt.Errorf("Fail to marshal: %s", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("Fail to write file: %s", err)
+	}
+
+	if err := os.Rename(tmpFile, stateFile); err != nil {
+		return fmt.Errorf("Fail to rename file: %s", err)
+	}
+
+	return nil
+}
+
+// LoadState reads the state of the last sync. An empty state is returned if
+// there is no sync yet.
+func LoadState() (*State, error) {
+	stateFile := filepath.Join(ConfDir, "state")
+	f, err := os.Open(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &State{}, nil
+		}
+		return nil, fmt.Errorf("Fail to open file: %s", err)
+	}
+	defer f.Close()
+
+	decoder := toml.NewDecoder(f)
+	var st State
+	err = decoder.Decode(&st)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to unmarshal: %s", err)
+	}
+
+	return &st, nil
+}
+
+func (st *State) GetProject(path string) *ProjectState {
+	for i := range st.Projects {
+		if st.Projects[i].Path == path {
+			return &st.Projects[i]
+		}
+	}
+
+	return nil
+}
+
+// manifestRevHash returns the commit of the manifest repo and the SHA-256
+// hash of the manifest file.
+func manifestRevHash(cfg *Config) (rev, hash string, err error) {
+	repoPath := filepath.Join(ConfDir, cfg.Manifest.Path)
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		err = fmt.Errorf("Fail to open manifest repo: %s", err)
+		return
+	}
+
+	h, err := repo.ResolveRevision(plumbing.Revision("HEAD"))
+	if err != nil {
+		err = fmt.Errorf("Fail to resolve manifest revision: %s", err)
+		return
+	}
+	rev = h.String()
+
+	content, err := os.ReadFile(filepath.Join(repoPath, cfg.Manifest.File))
+	if err != nil {
+		err = fmt.Errorf("Fail to read manifest: %s", err)
+		return
+	}
+	sum := sha256.Sum256(content)
+	hash = hex.EncodeToString(sum[:])
+
+	return
+}
+
+func lastSyncedString(ps *ProjectState) string {
+	if ps == nil {
+		return ""
+	}
+
+	str := ps.Time.Local().Format(time.DateTime)
+	if ps.Outcome != OutcomeOK {
+		str = fmt.Sprintf("%s (%s)", str, ps.Outcome)
+	}
+
+	return str
+}
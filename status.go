@@ -1,10 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
@@ -13,12 +19,61 @@ var CmdStatus = cli.Command{
 	Name:   "status",
 	Usage:  "List status of repositories",
 	Action: cmdStatus,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Usage:   "Show projects without changes as well",
+			Aliases: []string{"v"},
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print status in JSON format",
+		},
+		&cli.IntFlag{
+			Name:        "jobs",
+			Usage:       "How many projects are checked in parallel",
+			DefaultText: "number of CPUs",
+			Aliases:     []string{"j"},
+		},
+	},
 	Before: func(c *cli.Context) error {
 		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
 		return nil
 	},
 }
 
+type fileStatus struct {
+	Path string `json:"path"`
+	Code string `json:"code"`
+}
+
+type projectStatus struct {
+	Path        string       `json:"path"`
+	Name        string       `json:"name"`
+	Missing     bool         `json:"missing"`
+	Branch      string       `json:"branch,omitempty"`
+	Detached    bool         `json:"detached"`
+	Head        string       `json:"head,omitempty"`
+	ManifestRev string       `json:"manifest-rev,omitempty"`
+	Ahead       int          `json:"ahead"`
+	Behind      int          `json:"behind"`
+	Modified    []fileStatus `json:"modified"`
+	Untracked   []string     `json:"untracked"`
+	SyncError   string       `json:"sync-error,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// isClean tells whether there is nothing to show about the project.
+func (s *projectStatus) isClean() bool {
+	return !s.Missing && s.Error == "" && s.SyncError == "" &&
+		s.Ahead == 0 && s.Behind == 0 &&
+		len(s.Modified) == 0 && len(s.Untracked) == 0
+}
+
 func cmdStatus(ctx *cli.Context) error {
 	slog := log.WithFields(log.Fields{
 		"cmd": "status",
@@ -34,7 +89,17 @@ func cmdStatus(ctx *cli.Context) error {
 		return fmt.Errorf("Fail to load manifest: %s", err)
 	}
 
-	err = repoStatus(m, slog)
+	st, err := LoadState()
+	if err != nil {
+		return fmt.Errorf("Fail to load state: %s", err)
+	}
+
+	n := ctx.Int("jobs")
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	err = repoStatus(m, st, n, ctx.Bool("verbose"), ctx.Bool("json"), slog)
 	if err != nil {
 		return fmt.Errorf("Fail to list status: %s", err)
 	}
@@ -42,39 +107,197 @@ func cmdStatus(ctx *cli.Context) error {
 	return nil
 }
 
-func repoStatus(m *Manifest, slog *log.Entry) error {
-	for _, p := range m.Projects {
-		relPath := p.Path
-		repoPath := filepath.Join(ProjectRoot, relPath)
-		printStatus(repoPath, slog)
+func repoStatus(m *Manifest, st *State, numTasks int, verbose, asJson bool, slog *log.Entry) error {
+	// Statuses are stored by index, so that they are in manifest order
+	statuses := make([]projectStatus, len(m.Projects))
+	parallelDo(numTasks, len(m.Projects), func(i int) {
+		statuses[i] = getStatus(&m.Projects[i])
+	})
+
+	failed := 0
+	for i, p := range m.Projects {
+		s := &statuses[i]
+		if s.Error != "" {
+			slog.WithFields(log.Fields{
+				"project": p.Path,
+			}).Errorf("%s", s.Error)
+			failed++
+		}
+		if ps := st.GetProject(p.Path); ps != nil && ps.Outcome != OutcomeOK {
+			s.SyncError = fmt.Sprintf("Last sync %s: %s", ps.Outcome, ps.Error)
+		}
+	}
+
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(statuses); err != nil {
+			return fmt.Errorf("Fail to marshal: %s", err)
+		}
+	} else {
+		printLastSync(st)
+
+		clean := 0
+		for _, s := range statuses {
+			if s.isClean() && !verbose {
+				clean++
+				continue
+			}
+			printStatus(&s)
+		}
+
+		if clean > 0 {
+			fmt.Printf("%d project(s) without changes. Use -v to show them.\n", clean)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Fail to read status of %d project(s)", failed)
 	}
 
 	return nil
 }
 
-func printStatus(repoPath string, slog *log.Entry) error {
+func printLastSync(st *State) {
+	if st.Sync.Time.IsZero() {
+		fmt.Printf("Never synced\n")
+		return
+	}
+
+	failed := 0
+	for _, ps := range st.Projects {
+		if ps.Outcome != OutcomeOK {
+			failed++
+		}
+	}
+
+	dur := time.Duration(st.Sync.Duration * float64(time.Second)).Round(time.Second)
+	fmt.Printf("Last synced: %s (dur %s)", st.Sync.Time.Local().Format(time.DateTime), dur)
+	if failed > 0 {
+		fmt.Printf(", %d project(s) not synced", failed)
+	}
+	fmt.Printf("\n")
+}
+
+// getStatus collects the status of the project. Errors are recorded in the
+// status, so that the other projects are still listed.
+func getStatus(p *Project) projectStatus {
+	s := projectStatus{
+		Path:      p.Path,
+		Name:      p.Name,
+		Modified:  []fileStatus{},
+		Untracked: []string{},
+	}
+
+	repoPath := filepath.Join(ProjectRoot, p.Path)
+	if !isDir(repoPath) {
+		s.Missing = true
+		return s
+	}
+
 	r, err := git.PlainOpen(repoPath)
 	if err != nil {
-		return fmt.Errorf("Fail to open repo: %s", err)
+		s.Error = fmt.Sprintf("Fail to open repo: %s", err)
+		return s
+	}
+
+	headRef, err := r.Reference(plumbing.HEAD, false)
+	if err != nil {
+		s.Error = fmt.Sprintf("Fail to read HEAD: %s", err)
+		return s
+	}
+	if headRef.Type() == plumbing.SymbolicReference {
+		s.Branch = headRef.Target().Short()
+	} else {
+		s.Detached = true
+	}
+
+	head, err := r.ResolveRevision(plumbing.Revision("HEAD"))
+	if err != nil {
+		s.Error = fmt.Sprintf("Fail to resolve HEAD: %s", err)
+		return s
+	}
+	s.Head = head.String()
+
+	if manifestRef, err := findBranch(r, "manifest-rev"); err == nil {
+		s.ManifestRev = manifestRef.Hash().String()
+		s.Ahead, s.Behind, err = aheadBehind(r, *head, manifestRef.Hash())
+		if err != nil {
+			s.Error = fmt.Sprintf("Fail to compare with manifest-rev: %s", err)
+			return s
+		}
 	}
 
 	w, err := r.Worktree()
 	if err != nil {
-		return fmt.Errorf("Fail to get worktree: %s", err)
+		s.Error = fmt.Sprintf("Fail to get worktree: %s", err)
+		return s
 	}
 
 	status, err := w.Status()
 	if err != nil {
-		return fmt.Errorf("Fail to get worktree status: %s", err)
+		s.Error = fmt.Sprintf("Fail to get worktree status: %s", err)
+		return s
 	}
 
-	fmt.Printf("=== Status of %s\n", filepath.Base(repoPath))
-	for f, s := range status {
-		indicator := getIndicator(s)
-		fmt.Printf("  %s  %s\n", indicator, f)
+	for f, fs := range status {
+		if fs.Worktree == git.Untracked {
+			s.Untracked = append(s.Untracked, f)
+			continue
+		}
+		s.Modified = append(s.Modified, fileStatus{
+			Path: f,
+			Code: getIndicator(fs),
+		})
 	}
+	sort.Slice(s.Modified, func(i, j int) bool {
+		return s.Modified[i].Path < s.Modified[j].Path
+	})
+	sort.Strings(s.Untracked)
 
-	return nil
+	return s
+}
+
+func printStatus(s *projectStatus) {
+	fmt.Printf("project %-40s ", s.Path+"/")
+	switch {
+	case s.Missing:
+		fmt.Printf("(missing)\n")
+	case s.Error != "":
+		fmt.Printf("(error)\n")
+	default:
+		branch := "(detached)"
+		if !s.Detached {
+			branch = "branch " + s.Branch
+		}
+		fmt.Printf("%s", branch)
+
+		switch {
+		case s.ManifestRev == "":
+			fmt.Printf(", no manifest-rev")
+		case s.Ahead > 0 || s.Behind > 0:
+			fmt.Printf(", ahead %d, behind %d of manifest-rev", s.Ahead, s.Behind)
+		}
+		fmt.Printf("\n")
+	}
+
+	if len(s.Modified) > 0 {
+		fmt.Printf("  Modified:\n")
+		for _, f := range s.Modified {
+			fmt.Printf("    %s  %s\n", f.Code, f.Path)
+		}
+	}
+
+	if len(s.Untracked) > 0 {
+		fmt.Printf("  Untracked:\n")
+		for _, f := range s.Untracked {
+			fmt.Printf("    %s\n", f)
+		}
+	}
+
+	if s.SyncError != "" {
+		fmt.Printf("  %s\n", s.SyncError)
+	}
 }
 
 func getIndicator(s *git.FileStatus) string {
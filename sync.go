@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,13 +15,15 @@ import (
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/jedib0t/go-pretty/v6/table"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
 
 var CmdSync = cli.Command{
-	Name:  "sync",
-	Usage: "Update repositories",
+	Name:      "sync",
+	Usage:     "Update repositories",
+	ArgsUsage: "[projects...]",
 	Flags: []cli.Flag{
 		&cli.IntFlag{
 			Name:        "tasks",
@@ -33,15 +36,56 @@ var CmdSync = cli.Command{
 			Name:  "force-sync",
 			Usage: "Force updating repos",
 		},
+		&cli.BoolFlag{
+			Name:  "manifest",
+			Usage: "Update the manifest repo even if projects are specified",
+		},
+		&cli.StringFlag{
+			Name:        "groups",
+			Usage:       "Only sync projects of the groups (comma-separated, '-' prefix to exclude)",
+			DefaultText: "default, unless projects are specified",
+			Aliases:     []string{"g"},
+		},
+		&cli.BoolFlag{
+			Name:  "keep-removed",
+			Usage: "Keep projects which are removed from the manifest",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "Stop syncing after the first failure",
+		},
+		&cli.IntFlag{
+			Name:  "retry-fetches",
+			Usage: "How many times a fetch failed by transient errors is retried",
+			Value: 0,
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "Write a report of the sync to the file",
+		},
+		&cli.StringFlag{
+			Name:  "report-format",
+			Usage: "The format of the report: json, junit",
+			Value: "json",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Usage:   "Only print errors",
+			Aliases: []string{"q"},
+		},
 	},
 	Action: cmdSync,
 	Before: func(c *cli.Context) error {
 		SetProjectRoot(false)
+		return AcquireLock(LockExclusive, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
 		return nil
 	},
 }
 
-func syncManifest(cfg *Config) error {
+func syncManifest(ctx context.Context, cfg *Config, opts syncOptions) error {
 	mlog := log.WithFields(log.Fields{
 		"cmd":   "sync",
 		"stage": "manifest-sync",
@@ -52,14 +96,21 @@ func syncManifest(cfg *Config) error {
 		return fmt.Errorf("Fail to open manifest repo: %s", err)
 	}
 
-	err = repo.Fetch(&git.FetchOptions{
-		Progress: os.Stdout,
-	})
+	// Progress of the manifest repo alone doesn't interleave, but it's only
+	// readable on a terminal
+	fo := &git.FetchOptions{}
+	if !opts.quiet && isTerminal(os.Stdout) {
+		fo.Progress = os.Stdout
+	}
+	retries, err := fetchWithRetry(ctx, repo, fo, opts.retryFetches, mlog)
+	if retries > 0 {
+		mlog.Infof("Fetch retried %d time(s)", retries)
+	}
 	if err != nil {
 		if !errors.Is(err, git.NoErrAlreadyUpToDate) {
 			return fmt.Errorf("Fail to fetch update: %s", err)
 		} else {
-			mlog.Info("Manifest up-to-date")
+			mlog.Debug("Manifest up-to-date")
 		}
 	}
 
@@ -105,9 +156,34 @@ func cmdSync(ctx *cli.Context) error {
 		return fmt.Errorf("Fail to load config: %s", err)
 	}
 
-	err = syncManifest(cfg)
-	if err != nil {
-		return fmt.Errorf("Fail to sync manifest: %s", err)
+	opts := syncOptions{
+		force:        ctx.Bool("force-sync"),
+		failFast:     ctx.Bool("fail-fast"),
+		retryFetches: ctx.Int("retry-fetches"),
+		quiet:        ctx.Bool("quiet"),
+		report:       ctx.String("report"),
+		reportFormat: ctx.String("report-format"),
+	}
+
+	if opts.report != "" && !isReportFormat(opts.reportFormat) {
+		return fmt.Errorf("Unknown report format: %s", opts.reportFormat)
+	}
+
+	// Debugging messages are still printed if they are asked for
+	if opts.quiet && log.GetLevel() < log.DebugLevel {
+		log.SetLevel(log.ErrorLevel)
+	}
+
+	// Only the specified projects are synced against the current manifest,
+	// unless updating the manifest is asked for
+	args := ctx.Args().Slice()
+	partial := len(args) > 0
+	opts.partial = partial
+	if !partial || ctx.Bool("manifest") {
+		err = syncManifest(ctx.Context, cfg, opts)
+		if err != nil {
+			return fmt.Errorf("Fail to sync manifest: %s", err)
+		}
 	}
 
 	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
@@ -116,6 +192,17 @@ func cmdSync(ctx *cli.Context) error {
 		return fmt.Errorf("Fail to load manifest: %s", err)
 	}
 
+	// Like repo, projects in the notdefault group are left out, unless
+	// they're specified
+	groups := ctx.String("groups")
+	if groups == "" && !partial {
+		groups = "default"
+	}
+	projects, err := selectProjects(m, args, groups, "")
+	if err != nil {
+		return fmt.Errorf("Fail to select projects: %s", err)
+	}
+
 	n := ctx.Int("tasks")
 	// If -j option is not specified, look for 'sync-j' attr in manifest
 	if n <= 0 {
@@ -124,24 +211,63 @@ func cmdSync(ctx *cli.Context) error {
 			n = 1
 		}
 	}
+	opts.numTasks = n
 
-	force := ctx.Bool("force-sync")
-	err = syncRepos(m, n, force)
+	err = syncRepos(ctx.Context, cfg, m, projects, opts)
 	if err != nil {
-		return fmt.Errorf("Fail to init repos: %s", err)
+		return fmt.Errorf("Fail to init repos: %w", err)
+	}
+
+	// Removed projects are left to a full sync
+	if partial {
+		return nil
+	}
+
+	// Projects missing from the manifest may be imported by others synced
+	// just now, rather than removed
+	if m.Incomplete {
+		log.Warn("Some imports of the manifest are skipped. Sync again to sync the projects they import.")
+		return nil
+	}
+
+	err = updateProjectList(m, ctx.Bool("keep-removed"))
+	if err != nil {
+		return fmt.Errorf("Fail to remove projects: %s", err)
 	}
 
 	return nil
 }
 
+type syncOptions struct {
+	numTasks     int
+	force        bool
+	failFast     bool
+	retryFetches int
+	quiet        bool
+	report       string
+	reportFormat string
+	// Only some projects are synced
+	partial bool
+}
+
 type syncJob struct {
+	name      string
 	repo      string
 	revision  string
 	path      string
 	remote    string
+	hash      string
+	action    string
+	fetched   int64
+	start     time.Time
+	dur       time.Duration
+	retries   int
 	err       error
 	log       *log.Entry
+	prog      *jobProgress
 	force     bool
+	maxRetry  int
+	tagFirst  bool
 	copyFiles []Copyfile
 	linkFiles []Linkfile
 }
@@ -172,24 +298,35 @@ func findBranch(repo *git.Repository, name string) (*plumbing.Reference, error)
 	return found, nil
 }
 
-func pullUpdate(path string, j syncJob) error {
+func pullUpdate(ctx context.Context, path string, j *syncJob) error {
 	jlog := j.log
 
-	jlog.Info("Pull update")
+	jlog.Debug("Pull update")
 	repo, err := git.PlainOpen(path)
 	if err != nil {
 		return fmt.Errorf("Fail to open git repo: %s", err)
 	}
 
-	err = repo.Fetch(&git.FetchOptions{
+	j.action = ActionFetch
+	j.prog.SetPhase(PhaseFetch)
+	j.retries, err = fetchWithRetry(ctx, repo, &git.FetchOptions{
 		RemoteName: j.remote,
-		Progress:   os.Stdout,
-	})
+		Progress:   j.prog,
+		Tags:       j.fetchTags(),
+	}, j.maxRetry, jlog)
+	upToDate := false
 	if err != nil {
 		if !errors.Is(err, git.NoErrAlreadyUpToDate) {
 			return fmt.Errorf("Fail to fetch update: %s", err)
 		} else {
-			jlog.Info("Remote up-to-date")
+			jlog.Debug("Remote up-to-date")
+			upToDate = true
+		}
+	}
+
+	if j.revision == remoteHeadRevision {
+		if err := setRemoteHead(ctx, repo, j.remote); err != nil {
+			return fmt.Errorf("Fail to find default branch: %s", err)
 		}
 	}
 
@@ -197,6 +334,7 @@ func pullUpdate(path string, j syncJob) error {
 	if err != nil {
 		return fmt.Errorf("Fail to parse revision: %s", err)
 	}
+	j.hash = remoteHash.String()
 
 	newBranchNeeded := false
 	localRef, err := findBranch(repo, "manifest-rev")
@@ -212,8 +350,13 @@ func pullUpdate(path string, j syncJob) error {
 		newBranchNeeded = true
 	}
 
+	if upToDate && !newBranchNeeded {
+		j.action = ActionUpToDate
+	}
+
 	//TODO: Create new branch
 	if newBranchNeeded {
+		j.prog.SetPhase(PhaseCheckout)
 		w, _ := repo.Worktree()
 
 		// Create new branch 'manifest-rev' pointing to the target revision
@@ -239,14 +382,81 @@ func pullUpdate(path string, j syncJob) error {
 	return nil
 }
 
-func parseRevision(repo *git.Repository, revStr string, j syncJob) (plumbing.Hash, error) {
-	return resolveRevision(repo, j.remote, revStr)
+func parseRevision(repo *git.Repository, revStr string, j *syncJob) (plumbing.Hash, error) {
+	return resolveProjectRevision(repo, j.remote, revStr, j.tagFirst)
+}
+
+// fetchTags returns which tags are fetched. All of them are if revisions may
+// be tags not on any branch.
+func (j *syncJob) fetchTags() git.TagMode {
+	if j.tagFirst {
+		return git.AllTags
+	}
+
+	return git.TagFollowing
+}
+
+// stagingDir is where repos are cloned before being moved to their paths.
+func stagingDir() string {
+	return filepath.Join(ConfDir, "tmp")
+}
+
+// cloneRepo clones the repo into a staging directory, and moves it to the
+// path only when it's completely done. If the path exists, it's replaced.
+func cloneRepo(ctx context.Context, path string, j *syncJob) error {
+	jlog := j.log
+
+	jlog.Debug("Clone repo")
+	if err := os.MkdirAll(stagingDir(), 0755); err != nil {
+		return fmt.Errorf("Fail to create staging dir: %s", err)
+	}
+
+	tmpPath, err := os.MkdirTemp(stagingDir(), filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("Fail to create staging dir: %s", err)
+	}
+	// Nothing is left if the repo is moved already
+	defer os.RemoveAll(tmpPath)
+
+	err = initRepo(ctx, tmpPath, j)
+	if err != nil {
+		return err
+	}
+
+	// The old repo is moved aside, and only removed once the new one is in
+	// place. The move may fail, e.g. across file systems.
+	oldPath := ""
+	if isDir(path) {
+		oldPath = fmt.Sprintf("%s.gorepo-old-%d", path, os.Getpid())
+		jlog.Debugf("Move old repo %s to %s", path, oldPath)
+		if err := os.Rename(path, oldPath); err != nil {
+			return fmt.Errorf("Fail to move old repo aside: %s", err)
+		}
+	}
+
+	jlog.Debugf("Move %s to %s", tmpPath, path)
+	if err := os.Rename(tmpPath, path); err != nil {
+		if oldPath != "" {
+			if err := os.Rename(oldPath, path); err != nil {
+				jlog.Errorf("Fail to restore old repo from %s: %s", oldPath, err)
+			}
+		}
+		return fmt.Errorf("Fail to move repo into place: %s", err)
+	}
+
+	if oldPath != "" {
+		jlog.Debugf("Remove old repo %s", oldPath)
+		if err := os.RemoveAll(oldPath); err != nil {
+			jlog.Warnf("Fail to remove old repo %s: %s", oldPath, err)
+		}
+	}
+
+	return nil
 }
 
-func cloneRepo(path string, j syncJob) error {
+func initRepo(ctx context.Context, path string, j *syncJob) error {
 	jlog := j.log
 
-	jlog.Info("Clone repo")
 	repo, err := git.PlainInit(path, false)
 	if err != nil {
 		return fmt.Errorf("Fail to init new repo: %s", err)
@@ -262,20 +472,30 @@ func cloneRepo(path string, j syncJob) error {
 	}
 
 	jlog.Debug("fetch remote")
-	err = repo.Fetch(&git.FetchOptions{
+	j.prog.SetPhase(PhaseFetch)
+	j.retries, err = fetchWithRetry(ctx, repo, &git.FetchOptions{
 		RemoteName: j.remote,
-		Progress:   os.Stdout,
-	})
+		Progress:   j.prog,
+		Tags:       j.fetchTags(),
+	}, j.maxRetry, jlog)
 	if err != nil {
 		return fmt.Errorf("Fail to fetch update: %s", err)
 	}
 
+	if j.revision == remoteHeadRevision {
+		if err := setRemoteHead(ctx, repo, j.remote); err != nil {
+			return fmt.Errorf("Fail to find default branch: %s", err)
+		}
+	}
+
 	jlog.Debug("create branch")
+	j.prog.SetPhase(PhaseCheckout)
 	w, _ := repo.Worktree()
 	h, err := parseRevision(repo, j.revision, j)
 	if err != nil {
 		return fmt.Errorf("Fail to parse revision: %s", err)
 	}
+	j.hash = h.String()
 
 	// Create new branch 'manifest-rev' pointing to the target revision
 	err = w.Checkout(&git.CheckoutOptions{
@@ -426,7 +646,7 @@ func doCopyfile(repoPath string, c Copyfile, clog *log.Entry) error {
 	return nil
 }
 
-func isRemoteDifferent(repoPath string, job syncJob) bool {
+func isRemoteDifferent(repoPath string, job *syncJob) bool {
 	jlog := job.log
 	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
@@ -455,23 +675,23 @@ func isRemoteDifferent(repoPath string, job syncJob) bool {
 	return isDifferent
 }
 
-func reCreateDir(repoPath string) (err error) {
-	err = os.RemoveAll(repoPath)
-	if err != nil {
-		return
-	}
-	err = os.MkdirAll(repoPath, 0755)
-
-	return
-}
-
-func doJob(j syncJob) error {
+func doJob(ctx context.Context, j *syncJob) error {
 	jlog := j.log
 	repoPath := j.path
 	if !filepath.IsAbs(repoPath) {
 		repoPath = filepath.Join(ProjectRoot, repoPath)
 	}
 
+	// Bytes fetched are measured by the growth of the object database
+	objectsPath := filepath.Join(repoPath, ".git", "objects")
+	objectsSize := dirSize(objectsPath)
+	defer func() {
+		j.fetched = dirSize(objectsPath) - objectsSize
+		if j.fetched < 0 {
+			j.fetched = 0
+		}
+	}()
+
 	var err error
 	if isDir(repoPath) {
 		if isRemoteDifferent(repoPath, j) {
@@ -484,21 +704,24 @@ func doJob(j syncJob) error {
 			}
 
 			jlog.Infof("The repo %s has different remote. Force update.", j.path)
-			err = reCreateDir(repoPath)
-			if err != nil {
-				return err
-			}
-			err = cloneRepo(repoPath, j)
+			// The old objects are gone with the old repo
+			objectsSize = 0
+			j.action = ActionRecloned
+			err = cloneRepo(ctx, repoPath, j)
 		} else {
-			err = pullUpdate(repoPath, j)
+			err = pullUpdate(ctx, repoPath, j)
 		}
 	} else {
-		err = cloneRepo(repoPath, j)
+		j.action = ActionClone
+		err = cloneRepo(ctx, repoPath, j)
 	}
 	if err != nil {
 		return err
 	}
 
+	if len(j.copyFiles) > 0 || len(j.linkFiles) > 0 {
+		j.prog.SetPhase(PhaseCopyfile)
+	}
 	for _, c := range j.copyFiles {
 		if err := doCopyfile(repoPath, c, jlog); err != nil {
 			return err
@@ -514,38 +737,43 @@ func doJob(j syncJob) error {
 	return nil
 }
 
-func worker(idx int, stopCh <-chan bool, jobCh <-chan syncJob, errCh chan<- syncJob, wg *sync.WaitGroup, logger *log.Entry) {
+func worker(ctx context.Context, idx int, jobCh <-chan syncJob, errCh chan<- syncJob, wg *sync.WaitGroup, prog *progress, logger *log.Entry) {
 	wlog := logger.WithFields(log.Fields{
 		"worker": idx,
 	})
 	defer wg.Done()
 
-	for {
-		select {
-		case <-stopCh:
-			wlog.Debug("exit")
-			return
-		case j := <-jobCh:
-			jlog := wlog.WithFields(log.Fields{
-				"path":   j.path,
-				"remote": j.remote,
-			})
-			jlog.Debugf("Repo: %s", j.repo)
-
-			j.log = jlog
-			start := time.Now()
-			err := doJob(j)
-			dur := time.Since(start).Round(time.Second)
-			if err != nil {
-				jlog.Errorf("Fail to do job (dur %s): %s", dur, err)
-			} else {
-				jlog.Infof("Job done (dur %s)", dur)
-			}
+	for j := range jobCh {
+		// Jobs received after cancellation are not started at all
+		if ctx.Err() != nil {
+			continue
+		}
 
-			j.err = err
-			errCh <- j
+		jlog := wlog.WithFields(log.Fields{
+			"path":   j.path,
+			"remote": j.remote,
+		})
+		jlog.Debugf("Repo: %s", j.repo)
+
+		j.log = jlog
+		j.prog = prog.Job(idx)
+		j.prog.Begin(j.path)
+		j.start = time.Now()
+		err := doJob(ctx, &j)
+		j.dur = time.Since(j.start)
+		j.prog.Finish(err)
+		dur := j.dur.Round(time.Second)
+		if err != nil {
+			jlog.Errorf("Fail to do job (dur %s): %s", dur, err)
+		} else {
+			jlog.Debugf("Job done (dur %s)", dur)
 		}
+
+		j.err = err
+		errCh <- j
 	}
+
+	wlog.Debug("exit")
 }
 
 func createJob(m *Manifest, p *Project) (syncJob, error) {
@@ -560,10 +788,12 @@ func createJob(m *Manifest, p *Project) (syncJob, error) {
 	}
 
 	tmp := syncJob{
+		name:      p.Name,
 		repo:      url,
 		revision:  rev,
 		path:      p.Path,
 		remote:    name,
+		tagFirst:  p.TagFirst,
 		copyFiles: p.Copyfiles,
 		linkFiles: p.Linkfiles,
 	}
@@ -571,7 +801,7 @@ func createJob(m *Manifest, p *Project) (syncJob, error) {
 	return tmp, nil
 }
 
-func setupDirAll(j syncJob) {
+func setupDirAll(j *syncJob) {
 	dir := filepath.Dir(j.path)
 	if !filepath.IsAbs(dir) {
 		dir = filepath.Join(ProjectRoot, dir)
@@ -582,50 +812,238 @@ func setupDirAll(j syncJob) {
 	}
 }
 
-func syncRepos(m *Manifest, numTasks int, force bool) error {
+func syncRepos(ctx context.Context, cfg *Config, m *Manifest, projects []Project, opts syncOptions) error {
 	slog := log.WithFields(log.Fields{
 		"cmd": "sync",
 	})
-	stopCh := make(chan bool)
+	syncStart := time.Now()
+
+	// Clean up leftovers of clones which never completed
+	if err := os.RemoveAll(stagingDir()); err != nil {
+		return fmt.Errorf("Fail to clean up staging dir: %s", err)
+	}
+
+	// Projects whose jobs can't be created are failed without being started
+	var jobs []syncJob
+	var skipped []syncJob
+	for _, p := range projects {
+		job, err := createJob(m, &p)
+		if err != nil {
+			slog.Errorf("Fail to create the job %s: %s", p.Name, err)
+			skipped = append(skipped, syncJob{
+				name: p.Name,
+				path: p.Path,
+				err:  err,
+			})
+			continue
+		}
+
+		setupDirAll(&job)
+		job.force = opts.force
+		job.maxRetry = opts.retryFetches
+		jobs = append(jobs, job)
+	}
+
+	// Jobs are cancelled either by signals, or by the first failure in
+	// fail-fast mode
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	hasError := len(skipped) > 0
+	if opts.failFast && hasError {
+		slog.Warn("Stop syncing due to --fail-fast")
+		cancel()
+	}
+
+	prog := newProgress(len(jobs), opts.numTasks, opts.quiet)
+	prog.Start()
+	defer prog.Stop()
+
 	jobCh := make(chan syncJob)
 	errCh := make(chan syncJob)
 	var wg sync.WaitGroup
-	for i := 0; i < numTasks; i++ {
-		go worker(i, stopCh, jobCh, errCh, &wg, slog)
+	for i := 0; i < opts.numTasks; i++ {
 		wg.Add(1)
+		go worker(jobCtx, i, jobCh, errCh, &wg, prog, slog)
 	}
-	defer wg.Wait()
 
-	// Job dispatch
+	// Job dispatch. No more jobs are dispatched once cancelled.
 	go func() {
-		for _, p := range m.Projects {
-			job, err := createJob(m, &p)
-			if err != nil {
-				slog.Debugf("Skip the job %s: %s", p.Name, err)
-				continue
+		defer close(jobCh)
+		for _, job := range jobs {
+			select {
+			case jobCh <- job:
+			case <-jobCtx.Done():
+				return
 			}
-
-			setupDirAll(job)
-			job.force = force
-			jobCh <- job
 		}
 	}()
 
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+
 	// Fetch result of processing
-	hasError := false
-	for i := 0; i < len(m.Projects); i++ {
-		j := <-errCh
+	var results []syncJob
+	for j := range errCh {
 		if j.err != nil {
 			slog.Errorf("Job %s failed", j.path)
+			if opts.failFast && !hasError {
+				slog.Warn("Stop syncing due to --fail-fast")
+				cancel()
+			}
 			hasError = true
 		}
+		results = append(results, j)
+	}
+	prog.Stop()
+
+	printRetrySummary(slog, results)
+
+	err := saveSyncState(cfg, m, append(results, skipped...), syncStart, !hasError, opts.partial)
+	if err != nil {
+		slog.Errorf("Fail to save state: %s", err)
+	}
+
+	if opts.report != "" {
+		all := reportJobs(m, jobs, append(results, skipped...))
+		err := writeReport(opts.report, opts.reportFormat, all, syncStart, !hasError && ctx.Err() == nil)
+		if err != nil {
+			slog.Errorf("Fail to write report: %s", err)
+		}
 	}
 
-	close(stopCh)
+	if ctx.Err() != nil {
+		printInterruptSummary(slog, jobs, results)
+		return &exitError{
+			err:  fmt.Errorf("Interrupted"),
+			code: ExitInterrupted,
+		}
+	}
 
 	if hasError {
-		return fmt.Errorf("Error happens")
+		failed := printFailedJobs(append(skipped, results...), len(jobs)-len(results))
+		return &exitError{
+			err:  fmt.Errorf("%d project(s) failed", failed),
+			code: ExitSyncFailed,
+		}
 	}
 
 	return nil
 }
+
+// printFailedJobs prints a table of failed jobs with their errors, and
+// returns the number of them.
+func printFailedJobs(results []syncJob, notStarted int) int {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Failed project", "Error"})
+
+	failed := 0
+	for _, j := range results {
+		if j.err == nil {
+			continue
+		}
+
+		t.AppendRow(table.Row{j.path, j.err.Error()})
+		failed++
+	}
+
+	t.AppendFooter(table.Row{"Total", failed})
+	if notStarted > 0 {
+		t.AppendFooter(table.Row{"Not started", notStarted})
+	}
+	t.Render()
+
+	return failed
+}
+
+func printRetrySummary(slog *log.Entry, results []syncJob) {
+	total := 0
+	for _, j := range results {
+		if j.retries > 0 {
+			slog.Infof("Fetch of %s retried %d time(s)", j.path, j.retries)
+			total += j.retries
+		}
+	}
+
+	if total > 0 {
+		slog.Infof("Fetches retried %d time(s) in total", total)
+	}
+}
+
+func printInterruptSummary(slog *log.Entry, jobs, results []syncJob) {
+	done := 0
+	failed := 0
+	for _, j := range results {
+		if j.err != nil {
+			failed++
+			continue
+		}
+		done++
+		slog.Infof("Synced %s", j.path)
+	}
+
+	slog.Warnf("Sync interrupted: %d done, %d failed, %d not started",
+		done, failed, len(jobs)-len(results))
+}
+
+// saveSyncState records results of jobs in the state file. Projects which
+// are not synced this time keep their previous records, and so does the
+// workspace if the sync is partial.
+func saveSyncState(cfg *Config, m *Manifest, jobs []syncJob, start time.Time, success, partial bool) error {
+	st, err := LoadState()
+	if err != nil {
+		st = &State{}
+	}
+
+	if !partial {
+		st.Sync.Time = start
+		st.Sync.Duration = time.Since(start).Seconds()
+		st.Sync.Success = success
+		if success {
+			st.Sync.LastSuccess = start
+		}
+		st.Sync.ManifestRev, st.Sync.ManifestHash, err = manifestRevHash(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	updated := make(map[string]ProjectState)
+	for _, j := range jobs {
+		ps := ProjectState{
+			Path:     j.path,
+			Name:     j.name,
+			Url:      j.repo,
+			Revision: j.revision,
+			Hash:     j.hash,
+			Time:     j.start,
+			Duration: j.dur.Seconds(),
+			Outcome:  OutcomeOK,
+		}
+		if j.err != nil {
+			ps.Outcome = OutcomeFailed
+			ps.Error = j.err.Error()
+		}
+		if j.start.IsZero() {
+			ps.Time = start
+			ps.Outcome = OutcomeSkipped
+		}
+		updated[j.path] = ps
+	}
+
+	// Keep manifest order, and drop projects not in the manifest anymore
+	var projects []ProjectState
+	for _, p := range m.Projects {
+		if ps, ok := updated[p.Path]; ok {
+			projects = append(projects, ps)
+		} else if ps := st.GetProject(p.Path); ps != nil {
+			projects = append(projects, *ps)
+		}
+	}
+	st.Projects = projects
+
+	return SaveState(st)
+}
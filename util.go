@@ -1,15 +1,19 @@
 package main
 
 import (
+	"container/heap"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/hash"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -45,6 +49,39 @@ func findProjectRoot() (prjRoot string, err error) {
 	return
 }
 
+// The revision of the default branch of the remote, which is resolved by
+// refs/remotes/<remote>/HEAD set by setRemoteHead
+const remoteHeadRevision = "HEAD"
+
+// setRemoteHead points refs/remotes/<remote>/HEAD to the branch which HEAD of
+// the remote points to, like git clone does.
+func setRemoteHead(ctx context.Context, repo *git.Repository, remoteName string) error {
+	remote, err := repo.Remote(remoteName)
+	if err != nil {
+		return err
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Fail to list remote refs: %s", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() != plumbing.HEAD || ref.Type() != plumbing.SymbolicReference {
+			continue
+		}
+		if !ref.Target().IsBranch() {
+			break
+		}
+
+		target := plumbing.NewRemoteReferenceName(remoteName, ref.Target().Short())
+		name := plumbing.NewRemoteHEADReferenceName(remoteName)
+		return repo.Storer.SetReference(plumbing.NewSymbolicReference(name, target))
+	}
+
+	return fmt.Errorf("No default branch is found in remote %s", remoteName)
+}
+
 func resolveRevision(repo *git.Repository, remote, revStr string) (out plumbing.Hash, err error) {
 	var b []byte
 	var tmp *plumbing.Hash
@@ -78,3 +115,223 @@ func resolveRevision(repo *git.Repository, remote, revStr string) (out plumbing.
 
 	return
 }
+
+// resolveProjectRevision resolves the revision like resolveRevision, except
+// that bare names are tried as tags first if tagFirst is set, like git does
+// for the revisions of west.
+func resolveProjectRevision(repo *git.Repository, remote, revStr string, tagFirst bool) (plumbing.Hash, error) {
+	if tagFirst && !plumbing.IsHash(revStr) && !strings.HasPrefix(revStr, "refs/") {
+		if h, err := repo.ResolveRevision(plumbing.Revision("refs/tags/" + revStr)); err == nil {
+			return *h, nil
+		}
+	}
+
+	return resolveRevision(repo, remote, revStr)
+}
+
+// parallelDo calls fn for every index from 0 to count-1, using n goroutines.
+func parallelDo(n, count int, fn func(i int)) {
+	if n <= 0 {
+		n = 1
+	}
+
+	idxCh := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+}
+
+// dirSize returns the total size of regular files under the directory, or 0
+// if it doesn't exist.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if fi, err := d.Info(); err == nil {
+				size += fi.Size()
+			}
+		}
+		return nil
+	})
+
+	return size
+}
+
+// commitQueue is a priority queue of commits, the newest first.
+type commitQueue []*object.Commit
+
+func (q commitQueue) Len() int           { return len(q) }
+func (q commitQueue) Less(i, j int) bool { return q[i].Committer.When.After(q[j].Committer.When) }
+func (q commitQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)        { *q = append(*q, x.(*object.Commit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// oldestCommitQueue is a priority queue of commits, the oldest first.
+type oldestCommitQueue struct{ commitQueue }
+
+func (q oldestCommitQueue) Less(i, j int) bool { return q.commitQueue.Less(j, i) }
+
+// The number of commits walked after the walk of uniqueCommits seems done
+const walkSlop = 5
+
+// aheadBehind counts commits reachable only from local, and only from
+// upstream.
+func aheadBehind(repo *git.Repository, local, upstream plumbing.Hash) (ahead, behind int, err error) {
+	localOnly, upstreamOnly, err := uniqueCommits(repo, local, upstream)
+	return len(localOnly), len(upstreamOnly), err
+}
+
+// uniqueCommits returns commits reachable only from local, and only from
+// upstream, the newest first. Both sides are walked at once from the newest
+// commits, and the walk stops when all remaining commits are reachable from
+// both, so that the whole history isn't walked. Like paint_down_to_common of
+// git, a commit is walked again whenever it's found reachable from the other
+// side, since commits of the same time come in any order.
+func uniqueCommits(repo *git.Repository, local, upstream plumbing.Hash) (localOnly, upstreamOnly []*object.Commit, err error) {
+	if local == upstream {
+		return
+	}
+
+	const (
+		fromLocal    = 1
+		fromUpstream = 2
+		fromBoth     = fromLocal | fromUpstream
+	)
+
+	flags := make(map[plumbing.Hash]int)
+	// The flags of commits when their parents were last walked
+	walked := make(map[plumbing.Hash]int)
+	var visited []*object.Commit
+	q := &commitQueue{}
+	// Visited commits which may be reachable from one side only. Commits
+	// found reachable from both are dropped when they come to the top.
+	singles := &oldestCommitQueue{}
+
+	// A commit may be queued more than once, so queued entries are counted
+	// per commit, along with the ones not known to be reachable from both.
+	queued := make(map[plumbing.Hash]int)
+	nonBoth := 0
+
+	push := func(c *object.Commit) {
+		queued[c.Hash]++
+		if flags[c.Hash] != fromBoth {
+			nonBoth++
+		}
+		heap.Push(q, c)
+	}
+	pop := func() *object.Commit {
+		c := heap.Pop(q).(*object.Commit)
+		queued[c.Hash]--
+		if flags[c.Hash] != fromBoth {
+			nonBoth--
+		}
+		return c
+	}
+	mark := func(h plumbing.Hash, f int) {
+		if flags[h] != fromBoth && flags[h]|f == fromBoth {
+			nonBoth -= queued[h]
+		}
+		flags[h] |= f
+	}
+
+	for _, s := range []struct {
+		hash plumbing.Hash
+		flag int
+	}{{local, fromLocal}, {upstream, fromUpstream}} {
+		c, err := repo.CommitObject(s.hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Fail to read commit: %s", err)
+		}
+		mark(s.hash, s.flag)
+		push(c)
+	}
+
+	// Whether the walk has to go on. It does while any queued commit is not
+	// known to be reachable from both, or may still be a descendant of a
+	// commit reachable from one side only. Parents aren't newer than their
+	// children, so only queued commits older than all of them are done.
+	pending := func() bool {
+		if nonBoth > 0 {
+			return true
+		}
+
+		for singles.Len() > 0 && flags[singles.commitQueue[0].Hash] == fromBoth {
+			heap.Pop(singles)
+		}
+		if singles.Len() == 0 || q.Len() == 0 {
+			return false
+		}
+
+		return !(*q)[0].Committer.When.Before(singles.commitQueue[0].Committer.When)
+	}
+
+	// Like git, a few more commits are walked after it seems done, in case
+	// of clock skew, where a parent is newer than its child.
+	slop := walkSlop
+	for q.Len() > 0 {
+		if pending() {
+			slop = walkSlop
+		} else if slop--; slop == 0 {
+			break
+		}
+
+		c := pop()
+		f := flags[c.Hash]
+		last, ok := walked[c.Hash]
+		if ok && last == f {
+			continue
+		}
+		if !ok {
+			visited = append(visited, c)
+			if f != fromBoth {
+				heap.Push(singles, c)
+			}
+		}
+		walked[c.Hash] = f
+
+		for _, h := range c.ParentHashes {
+			if flags[h]|f == flags[h] {
+				continue
+			}
+			mark(h, f)
+
+			parent, err := repo.CommitObject(h)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Fail to read commit: %s", err)
+			}
+			push(parent)
+		}
+	}
+
+	for _, c := range visited {
+		switch flags[c.Hash] {
+		case fromLocal:
+			localOnly = append(localOnly, c)
+		case fromUpstream:
+			upstreamOnly = append(upstreamOnly, c)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// testHistory builds commits in memory, all made in the same second unless
+// told otherwise.
+type testHistory struct {
+	t    *testing.T
+	repo *git.Repository
+	when time.Time
+	n    int
+}
+
+func newTestHistory(t *testing.T) *testHistory {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testHistory{t: t, repo: repo, when: time.Unix(1700000000, 0)}
+}
+
+func (h *testHistory) commit(parents ...plumbing.Hash) plumbing.Hash {
+	h.n++
+	sig := object.Signature{Name: "test", Email: "test@example.com", When: h.when}
+	c := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      fmt.Sprintf("commit %d", h.n),
+		ParentHashes: parents,
+	}
+
+	obj := h.repo.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		h.t.Fatal(err)
+	}
+	hash, err := h.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	return hash
+}
+
+// chain adds n commits on top of the parent, which may be zero.
+func (h *testHistory) chain(parent plumbing.Hash, n int) plumbing.Hash {
+	for i := 0; i < n; i++ {
+		if parent.IsZero() {
+			parent = h.commit()
+		} else {
+			parent = h.commit(parent)
+		}
+	}
+
+	return parent
+}
+
+// ancestors returns the commit with all its ancestors.
+func (h *testHistory) ancestors(hash plumbing.Hash) map[plumbing.Hash]bool {
+	out := make(map[plumbing.Hash]bool)
+	stack := []plumbing.Hash{hash}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if out[cur] {
+			continue
+		}
+		out[cur] = true
+
+		c, err := h.repo.CommitObject(cur)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		stack = append(stack, c.ParentHashes...)
+	}
+
+	return out
+}
+
+func TestAheadBehind(t *testing.T) {
+	tests := []struct {
+		name   string
+		build  func(h *testHistory) (local, upstream plumbing.Hash)
+		ahead  int
+		behind int
+	}{
+		{
+			name: "same commit",
+			build: func(h *testHistory) (plumbing.Hash, plumbing.Hash) {
+				base := h.chain(plumbing.ZeroHash, 3)
+				return base, base
+			},
+		},
+		{
+			name: "behind",
+			build: func(h *testHistory) (plumbing.Hash, plumbing.Hash) {
+				base := h.chain(plumbing.ZeroHash, 10)
+				return base, h.chain(base, 1)
+			},
+			behind: 1,
+		},
+		{
+			name: "ahead",
+			build: func(h *testHistory) (plumbing.Hash, plumbing.Hash) {
+				base := h.chain(plumbing.ZeroHash, 10)
+				return h.chain(base, 1), base
+			},
+			ahead: 1,
+		},
+		{
+			name: "diverged",
+			build: func(h *testHistory) (plumbing.Hash, plumbing.Hash) {
+				base := h.chain(plumbing.ZeroHash, 10)
+				return h.chain(base, 2), h.chain(base, 3)
+			},
+			ahead:  2,
+			behind: 3,
+		},
+		{
+			name: "upstream merged",
+			build: func(h *testHistory) (plumbing.Hash, plumbing.Hash) {
+				base := h.chain(plumbing.ZeroHash, 10)
+				upstream := h.chain(base, 2)
+				local := h.chain(base, 1)
+				return h.commit(local, upstream), upstream
+			},
+			ahead: 2,
+		},
+		{
+			name: "unrelated",
+			build: func(h *testHistory) (plumbing.Hash, plumbing.Hash) {
+				return h.chain(plumbing.ZeroHash, 2), h.chain(plumbing.ZeroHash, 3)
+			},
+			ahead:  2,
+			behind: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHistory(t)
+			local, upstream := tt.build(h)
+
+			ahead, behind, err := aheadBehind(h.repo, local, upstream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ahead != tt.ahead || behind != tt.behind {
+				t.Errorf("got ahead %d behind %d, want %d %d", ahead, behind, tt.ahead, tt.behind)
+			}
+		})
+	}
+}
+
+// TestUniqueCommitsRandom compares random histories with merges, whose
+// commits have few distinct times, with sets of ancestors.
+func TestUniqueCommitsRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		h := newTestHistory(t)
+		var commits []plumbing.Hash
+		for j := 0; j < 30; j++ {
+			// Time never goes back in the history
+			h.when = h.when.Add(time.Duration(r.Intn(2)) * time.Second)
+
+			var parents []plumbing.Hash
+			if len(commits) > 0 {
+				parents = append(parents, commits[r.Intn(len(commits))])
+				if r.Intn(4) == 0 {
+					parents = append(parents, commits[r.Intn(len(commits))])
+				}
+			}
+			if len(parents) == 2 && parents[0] == parents[1] {
+				parents = parents[:1]
+			}
+			commits = append(commits, h.commit(parents...))
+		}
+
+		local := commits[len(commits)-1-r.Intn(5)]
+		upstream := commits[len(commits)-1-r.Intn(5)]
+		localSet, upstreamSet := h.ancestors(local), h.ancestors(upstream)
+
+		want := map[plumbing.Hash]bool{}
+		for c := range localSet {
+			if !upstreamSet[c] {
+				want[c] = true
+			}
+		}
+		for c := range upstreamSet {
+			if !localSet[c] {
+				want[c] = true
+			}
+		}
+
+		localOnly, upstreamOnly, err := uniqueCommits(h.repo, local, upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[plumbing.Hash]bool{}
+		for _, c := range append(localOnly, upstreamOnly...) {
+			got[c.Hash] = true
+		}
+		for _, c := range localOnly {
+			if !localSet[c.Hash] || upstreamSet[c.Hash] {
+				t.Errorf("history %d: %s is not only reachable from local", i, c.Hash)
+			}
+		}
+		for _, c := range upstreamOnly {
+			if !upstreamSet[c.Hash] || localSet[c.Hash] {
+				t.Errorf("history %d: %s is not only reachable from upstream", i, c.Hash)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("history %d: got %d unique commits, want %d", i, len(got), len(want))
+		}
+	}
+}
+
+func TestResolveProjectRevision(t *testing.T) {
+	h := newTestHistory(t)
+	branch := h.chain(plumbing.ZeroHash, 1)
+	tag := h.chain(branch, 1)
+
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewHashReference("refs/remotes/origin/v1", branch),
+		plumbing.NewHashReference("refs/tags/v1", tag),
+		plumbing.NewHashReference("refs/tags/v2", tag),
+	} {
+		if err := h.repo.Storer.SetReference(ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		rev      string
+		tagFirst bool
+		want     plumbing.Hash
+	}{
+		{"v1", false, branch},
+		{"v1", true, tag},
+		{"v2", true, tag},
+		{"refs/tags/v2", false, tag},
+		{branch.String(), true, branch},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveProjectRevision(h.repo, "origin", tt.rev, tt.tagFirst)
+		if err != nil {
+			t.Errorf("%s: %s", tt.rev, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.rev, got, tt.want)
+		}
+	}
+
+	if _, err := resolveProjectRevision(h.repo, "origin", "v2", false); err == nil {
+		t.Errorf("v2 is resolved as a branch")
+	}
+}
+
+// TestAheadBehindClockSkew has a merged commit whose time is older than its
+// parent, so it's walked after all commits of one side seem done.
+func TestAheadBehindClockSkew(t *testing.T) {
+	h := newTestHistory(t)
+	start := h.when
+
+	base := h.chain(plumbing.ZeroHash, 3)
+	h.when = start.Add(10 * time.Second)
+	side := h.commit(base)
+	h.when = start.Add(-100 * time.Second)
+	skewed := h.commit(side)
+	h.when = start.Add(20 * time.Second)
+	upstream := h.commit(skewed)
+	h.when = start.Add(30 * time.Second)
+	local := h.commit(side, skewed)
+
+	ahead, behind, err := aheadBehind(h.repo, local, upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead != 1 || behind != 1 {
+		t.Errorf("got ahead %d behind %d, want 1 1", ahead, behind)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// The revision of west projects if none is given
+const westDefaultRevision = "master"
+
+// The manifest file imported from a project if none is given
+const westManifestFile = "west.yml"
+
+type westFile struct {
+	Manifest westManifest `yaml:"manifest"`
+}
+
+type westManifest struct {
+	Defaults struct {
+		Remote   string `yaml:"remote"`
+		Revision string `yaml:"revision"`
+	} `yaml:"defaults"`
+	Remotes []struct {
+		Name    string `yaml:"name"`
+		UrlBase string `yaml:"url-base"`
+	} `yaml:"remotes"`
+	Projects []westProject `yaml:"projects"`
+	Self     struct {
+		Import any `yaml:"import"`
+	} `yaml:"self"`
+	GroupFilter []string `yaml:"group-filter"`
+}
+
+type westProject struct {
+	Name     string   `yaml:"name"`
+	Url      string   `yaml:"url"`
+	Remote   string   `yaml:"remote"`
+	RepoPath string   `yaml:"repo-path"`
+	Revision string   `yaml:"revision"`
+	Path     string   `yaml:"path"`
+	Groups   []string `yaml:"groups"`
+	Import   any      `yaml:"import"`
+}
+
+// westImport is the import of a project or the manifest repo itself, which
+// is a bool, a file or directory, or a map with filters.
+type westImport struct {
+	File          string
+	PathPrefix    string
+	NameAllowlist []string
+	NameBlocklist []string
+	PathAllowlist []string
+	PathBlocklist []string
+}
+
+// westLoader resolves a west manifest with its imports. Projects defined
+// first win, so the importing manifest overrides the imported ones.
+type westLoader struct {
+	projects []Project
+	names    map[string]bool
+	visited  map[string]bool
+	// Some imports of projects which are not synced yet are skipped
+	incomplete bool
+}
+
+// westSource is where manifest files are read from, by paths relative to it.
+type westSource interface {
+	readFile(name string) ([]byte, error)
+	isDir(name string) bool
+	// readDir returns names of files in the directory
+	readDir(name string) ([]string, error)
+	String() string
+}
+
+// dirSource reads files of the manifest repo from the disk.
+type dirSource string
+
+func (d dirSource) readFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), name))
+}
+
+func (d dirSource) isDir(name string) bool {
+	return isDir(filepath.Join(string(d), name))
+}
+
+func (d dirSource) readDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(string(d), name))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (d dirSource) String() string {
+	return string(d)
+}
+
+// treeSource reads files of a project at its manifest-rev, which is what the
+// manifest asks for as of the last sync, whatever is checked out.
+type treeSource struct {
+	name string
+	tree *object.Tree
+}
+
+func projectSource(p *Project) (*treeSource, error) {
+	repo, err := git.PlainOpen(filepath.Join(ProjectRoot, p.Path))
+	if err != nil {
+		return nil, fmt.Errorf("Fail to open repo: %s", err)
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("manifest-rev"), true)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to read manifest-rev: %s", err)
+	}
+
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("Fail to read commit: %s", err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("Fail to read tree: %s", err)
+	}
+
+	return &treeSource{name: p.Name + "@manifest-rev", tree: tree}, nil
+}
+
+func (t *treeSource) readFile(name string) ([]byte, error) {
+	f, err := t.tree.File(name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := f.Contents()
+	return []byte(content), err
+}
+
+func (t *treeSource) isDir(name string) bool {
+	_, err := t.tree.Tree(name)
+	return err == nil
+}
+
+func (t *treeSource) readDir(name string) ([]string, error) {
+	sub, err := t.tree.Tree(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range sub.Entries {
+		if e.Mode.IsFile() {
+			names = append(names, e.Name)
+		}
+	}
+
+	return names, nil
+}
+
+func (t *treeSource) String() string {
+	return t.name
+}
+
+// toStrings converts a yaml value of either a string or a list of strings.
+func toStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, s := range v {
+			out = append(out, fmt.Sprint(s))
+		}
+		return out
+	}
+
+	return nil
+}
+
+// parseWestImport returns nil if nothing is imported.
+func parseWestImport(v any) (*westImport, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if !v {
+			return nil, nil
+		}
+		return &westImport{File: westManifestFile}, nil
+	case string:
+		return &westImport{File: v}, nil
+	case map[string]any:
+		imp := &westImport{
+			File:          westManifestFile,
+			NameAllowlist: toStrings(v["name-allowlist"]),
+			NameBlocklist: toStrings(v["name-blocklist"]),
+			PathAllowlist: toStrings(v["path-allowlist"]),
+			PathBlocklist: toStrings(v["path-blocklist"]),
+		}
+		if file, ok := v["file"].(string); ok {
+			imp.File = file
+		}
+		if prefix, ok := v["path-prefix"].(string); ok {
+			imp.PathPrefix = prefix
+		}
+		return imp, nil
+	}
+
+	return nil, fmt.Errorf("Invalid import: %v", v)
+}
+
+func matchAnyPath(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allows checks the imported project against the filters. Paths are matched
+// before the prefix is added.
+func (imp *westImport) allows(p *Project) bool {
+	if len(imp.NameAllowlist) > 0 || len(imp.PathAllowlist) > 0 {
+		if !contains(imp.NameAllowlist, p.Name) && !matchAnyPath(p.Path, imp.PathAllowlist) {
+			return false
+		}
+	}
+
+	return !contains(imp.NameBlocklist, p.Name) && !matchAnyPath(p.Path, imp.PathBlocklist)
+}
+
+// disabledGroups returns groups disabled by the group filter. Later entries
+// override earlier ones.
+func disabledGroups(filter []string) map[string]bool {
+	disabled := make(map[string]bool)
+	for _, f := range filter {
+		switch {
+		case strings.HasPrefix(f, "-"):
+			disabled[f[1:]] = true
+		case strings.HasPrefix(f, "+"):
+			delete(disabled, f[1:])
+		}
+	}
+
+	return disabled
+}
+
+// toProject maps the west project to a project with its own URL, since
+// remotes of imported manifests may clash with each other.
+func (wm *westManifest) toProject(wp *westProject) (Project, error) {
+	p := Project{
+		Name:     wp.Name,
+		Path:     wp.Path,
+		Remote:   urlRemoteName,
+		Revision: wp.Revision,
+		Groups:   strings.Join(wp.Groups, ","),
+		URL:      wp.Url,
+		TagFirst: true,
+	}
+	if p.Path == "" {
+		p.Path = p.Name
+	}
+	if p.Revision == "" {
+		p.Revision = wm.Defaults.Revision
+	}
+	if p.Revision == "" {
+		p.Revision = westDefaultRevision
+	}
+
+	// Projects in disabled groups are only synced when asked for by names or
+	// groups
+	if len(wp.Groups) > 0 {
+		disabled := disabledGroups(wm.GroupFilter)
+		active := false
+		for _, g := range wp.Groups {
+			if !disabled[g] {
+				active = true
+			}
+		}
+		if !active {
+			p.Groups += ",notdefault"
+		}
+	}
+
+	if p.URL != "" {
+		return p, nil
+	}
+
+	remoteName := wp.Remote
+	if remoteName == "" {
+		remoteName = wm.Defaults.Remote
+	}
+	if remoteName == "" {
+		return p, fmt.Errorf("No remote or url is specified for %s", wp.Name)
+	}
+
+	repoPath := wp.RepoPath
+	if repoPath == "" {
+		repoPath = wp.Name
+	}
+
+	for _, r := range wm.Remotes {
+		if r.Name == remoteName {
+			p.URL, _ = url.JoinPath(r.UrlBase, repoPath)
+			return p, nil
+		}
+	}
+
+	return p, fmt.Errorf("No remote %s is found for %s", remoteName, wp.Name)
+}
+
+// add adds the project unless one with the same name is already added.
+func (l *westLoader) add(p Project) {
+	if l.names[p.Name] {
+		return
+	}
+	l.names[p.Name] = true
+	l.projects = append(l.projects, p)
+}
+
+// importFiles returns the manifest files of the import, which is either a
+// file or a directory of yaml files.
+func importFiles(src westSource, imp *westImport) ([]string, error) {
+	if !src.isDir(imp.File) {
+		return []string{imp.File}, nil
+	}
+
+	names, err := src.readDir(imp.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".yml") {
+			files = append(files, path.Join(imp.File, name))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// load resolves the manifest file in the source, whose projects are filtered
+// by the import if it's imported.
+func (l *westLoader) load(src westSource, name string, imp *westImport) error {
+	key := fmt.Sprintf("%s:%s", src, name)
+	if l.visited[key] {
+		return fmt.Errorf("Recursive import of %s", name)
+	}
+	l.visited[key] = true
+	defer delete(l.visited, key)
+
+	data, err := src.readFile(name)
+	if err != nil {
+		return fmt.Errorf("Fail to open file: %s", err)
+	}
+
+	var wf westFile
+	if err := yaml.Unmarshal(data, &wf); err != nil {
+		return fmt.Errorf("Fail to parse west manifest: %s", err)
+	}
+	wm := &wf.Manifest
+
+	// Projects filtered out by the import are nil, and so are their imports
+	projects := make([]*Project, len(wm.Projects))
+	for i := range wm.Projects {
+		p, err := wm.toProject(&wm.Projects[i])
+		if err != nil {
+			return err
+		}
+		if imp != nil {
+			if !imp.allows(&p) {
+				continue
+			}
+			p.Path = path.Join(imp.PathPrefix, p.Path)
+		}
+		projects[i] = &p
+		l.add(p)
+	}
+
+	// Imports of the manifest repo itself come before the ones of projects
+	selfImp, err := parseWestImport(wm.Self.Import)
+	if err != nil {
+		return fmt.Errorf("Fail to import in self: %s", err)
+	}
+	if selfImp != nil {
+		if err := l.importFrom(src, selfImp, imp); err != nil {
+			return err
+		}
+	}
+
+	for i, wp := range wm.Projects {
+		projImp, err := parseWestImport(wp.Import)
+		if err != nil {
+			return fmt.Errorf("Fail to import in %s: %s", wp.Name, err)
+		}
+		if projImp == nil || projects[i] == nil {
+			continue
+		}
+
+		// Imports are read from the project, so it has to be synced first
+		projSrc, err := projectSource(projects[i])
+		if err != nil {
+			log.Warnf("Skip import of %s, which is not synced yet: %s", wp.Name, err)
+			l.incomplete = true
+			continue
+		}
+
+		if err := l.importFrom(projSrc, projImp, imp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importFrom loads the manifests of the import in the source. The path
+// prefix of the outer import also applies to nested ones.
+func (l *westLoader) importFrom(src westSource, imp, outer *westImport) error {
+	files, err := importFiles(src, imp)
+	if err != nil {
+		return fmt.Errorf("Fail to import %s from %s: %s", imp.File, src, err)
+	}
+
+	if outer != nil {
+		imp.PathPrefix = path.Join(outer.PathPrefix, imp.PathPrefix)
+	}
+
+	for _, f := range files {
+		if err := l.load(src, f, imp); err != nil {
+			return fmt.Errorf("Fail to import %s from %s: %s", f, src, err)
+		}
+	}
+
+	return nil
+}
+
+// loadWestManifest maps projects of a west manifest, including the imported
+// ones, to projects with their own URLs. Files are read relative to the root
+// of the manifest repo, where imports of self are.
+func loadWestManifest(filePath string) (*Manifest, error) {
+	l := &westLoader{
+		names:   make(map[string]bool),
+		visited: make(map[string]bool),
+	}
+
+	root := manifestRepoRoot(filePath)
+	name, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to find manifest repo: %s", err)
+	}
+	if err := l.load(dirSource(root), name, nil); err != nil {
+		return nil, err
+	}
+
+	return &Manifest{Projects: l.projects, Incomplete: l.incomplete}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func writeFile(t *testing.T, filePath, content string) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commitFile commits the file to the repo at manifest-rev, and changes it in
+// the worktree afterwards.
+func commitFile(t *testing.T, repoPath, name, content, changed string) {
+	repo, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(repoPath, name), content)
+	if _, err := w.Add(name); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	h, err := w.Commit("west", &git.CommitOptions{Author: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName("manifest-rev"), h)
+	if err := repo.Storer.SetReference(ref); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(repoPath, name), changed)
+}
+
+func TestLoadWestManifestImports(t *testing.T) {
+	oldRoot := ProjectRoot
+	defer func() { ProjectRoot = oldRoot }()
+	ProjectRoot = t.TempDir()
+
+	manifestPath := filepath.Join(ProjectRoot, ".gorepo", "manifests")
+	writeFile(t, filepath.Join(manifestPath, "west.yml"), `manifest:
+  defaults:
+    revision: main
+  projects:
+    - name: zephyr
+      url: https://example.com/zephyr
+      import:
+        path-prefix: deps
+    - name: unsynced
+      url: https://example.com/unsynced
+      import: true
+`)
+
+	m, err := loadWestManifest(filepath.Join(manifestPath, "west.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Incomplete || len(m.Projects) != 2 {
+		t.Errorf("got %d projects, incomplete %v, want 2 and incomplete", len(m.Projects), m.Incomplete)
+	}
+
+	commitFile(t, filepath.Join(ProjectRoot, "zephyr"), "west.yml", `manifest:
+  projects:
+    - name: hal
+      url: https://example.com/hal
+      revision: v1.0
+`, "not a manifest: [")
+
+	m, err = loadWestManifest(filepath.Join(manifestPath, "west.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Projects) != 3 {
+		t.Fatalf("got %d projects, want 3", len(m.Projects))
+	}
+	if p := m.Projects[2]; p.Name != "hal" || p.Path != "deps/hal" || p.Revision != "v1.0" {
+		t.Errorf("got %s at %s of %s, want hal at deps/hal of v1.0", p.Name, p.Path, p.Revision)
+	}
+	if !m.Incomplete {
+		t.Errorf("unsynced is imported")
+	}
+}
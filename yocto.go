@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	bblayersBegin = "# BEGIN gorepo bblayers"
+	bblayersEnd   = "# END gorepo bblayers"
+
+	// The annotation of projects listing their layers to be used
+	bblayersAnnotation = "bblayers"
+)
+
+// The content of a new bblayers.conf besides the generated section
+const bblayersHeader = `# POKY_BBLAYERS_CONF_VERSION is increased each time build/conf/bblayers.conf
+# changes incompatibly
+POKY_BBLAYERS_CONF_VERSION = "2"
+
+BBPATH = "${TOPDIR}"
+BBFILES ?= ""
+
+`
+
+var CmdYocto = cli.Command{
+	Name:  "yocto",
+	Usage: "Helpers for Yocto projects",
+	Subcommands: []*cli.Command{
+		&CmdYoctoBblayers,
+		&CmdYoctoCheck,
+	},
+	Before: func(c *cli.Context) error {
+		SetProjectRoot(false)
+		return AcquireLock(LockShared, c.Duration("wait"))
+	},
+	After: func(c *cli.Context) error {
+		ReleaseLock()
+		return nil
+	},
+}
+
+var CmdYoctoBblayers = cli.Command{
+	Name:  "bblayers",
+	Usage: "Generate BBLAYERS in bblayers.conf from layers of synced projects",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Usage:   "The file to update, or '-' for stdout",
+			Value:   "build/conf/bblayers.conf",
+			Aliases: []string{"o"},
+		},
+		&cli.StringFlag{
+			Name:  "prefix",
+			Usage: "The prefix replacing the top directory of projects in layer paths",
+			Value: "${TOPDIR}/..",
+		},
+		&cli.StringSliceFlag{
+			Name:    "include",
+			Usage:   "Only use layers matching the pathspec (directory or glob)",
+			Aliases: []string{"i"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "exclude",
+			Usage:   "Don't use layers matching the pathspec (directory or glob)",
+			Aliases: []string{"x"},
+		},
+	},
+	Action: cmdYoctoBblayers,
+}
+
+// layer is a directory with conf/layer.conf in a project.
+type layer struct {
+	project *Project
+	// Relative to the project root
+	path string
+}
+
+// projectDirs returns the directories of all projects in the manifest.
+func projectDirs(m *Manifest) []string {
+	var dirs []string
+	for _, p := range m.Projects {
+		dirs = append(dirs, filepath.Join(ProjectRoot, p.Path))
+	}
+
+	return dirs
+}
+
+// Directories never searched for layers, which have test data of bitbake
+// and others with their own layer.conf
+var nonLayerDirs = map[string]bool{
+	".git":     true,
+	"lib":      true,
+	"tests":    true,
+	"testdata": true,
+}
+
+// Layers only used to test or as templates, which are left out unless asked
+// for by name
+var testLayers = map[string]bool{
+	"meta-selftest": true,
+	"meta-skeleton": true,
+}
+
+// findLayers returns directories containing conf/layer.conf in the repo,
+// relative to it. Directories of other projects nested in the repo, which are
+// in skipDirs, are skipped since their layers belong to them. Layers aren't
+// searched below other layers, except below the repo itself, like meta-arm
+// with meta-arm-bsp.
+func findLayers(repoPath string, skipDirs ...string) ([]string, error) {
+	repoPath = filepath.Clean(repoPath)
+	skip := make(map[string]bool)
+	for _, d := range skipDirs {
+		skip[filepath.Clean(d)] = true
+	}
+
+	var layers []string
+	err := filepath.WalkDir(repoPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p == repoPath {
+			if isFile(filepath.Join(p, "conf", "layer.conf")) {
+				layers = append(layers, ".")
+			}
+			return nil
+		}
+		if nonLayerDirs[d.Name()] || skip[p] {
+			return filepath.SkipDir
+		}
+
+		if isFile(filepath.Join(p, "conf", "layer.conf")) {
+			rel, err := filepath.Rel(repoPath, p)
+			if err != nil {
+				return err
+			}
+			layers = append(layers, filepath.ToSlash(rel))
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(layers)
+	return layers, nil
+}
+
+// discoverLayers finds layers of synced projects in manifest order.
+func discoverLayers(m *Manifest, ylog *log.Entry) ([]layer, error) {
+	var layers []layer
+	dirs := projectDirs(m)
+	for i := range m.Projects {
+		p := &m.Projects[i]
+		repoPath := filepath.Join(ProjectRoot, p.Path)
+		if !isDir(repoPath) {
+			ylog.Debugf("Skip %s which is not synced", p.Path)
+			continue
+		}
+
+		paths, err := findLayers(repoPath, dirs...)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to find layers in %s: %s", p.Path, err)
+		}
+
+		for _, lp := range paths {
+			layers = append(layers, layer{
+				project: p,
+				path:    filepath.ToSlash(filepath.Join(p.Path, lp)),
+			})
+		}
+	}
+
+	return layers, nil
+}
+
+// matchLayer checks whether the layer path relative to its project matches
+// any of the specs. Unlike pathspecs, '.' means only the top of the project.
+func matchLayer(rel string, specs []string) bool {
+	for _, spec := range specs {
+		if filepath.Clean(spec) == "." {
+			if rel == "." {
+				return true
+			}
+			continue
+		}
+
+		if matchPathspec(rel, []string{spec}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// namesLayer checks if any of the specs is the path or the name of the layer,
+// rather than a pattern matching it.
+func namesLayer(p string, specs []string) bool {
+	for _, spec := range specs {
+		spec = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(spec)), "/")
+		if spec == p || spec == path.Base(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// useLayer checks the layer against the layers listed by its project, either
+// by the annotation or by the manifest like kas, and the include/exclude
+// pathspecs. Test layers are only used if named by either of them.
+func useLayer(l layer, include, exclude []string) bool {
+	specs := l.project.Layers
+	value, hasAnnotation := l.project.GetAnnotation(bblayersAnnotation)
+	if hasAnnotation {
+		specs = splitGroups(value)
+	}
+
+	rel := strings.TrimPrefix(l.path, filepath.ToSlash(filepath.Clean(l.project.Path))+"/")
+	if l.path == filepath.ToSlash(filepath.Clean(l.project.Path)) {
+		rel = "."
+	}
+
+	if hasAnnotation || len(specs) > 0 {
+		if !matchLayer(rel, specs) {
+			return false
+		}
+	}
+
+	if testLayers[path.Base(l.path)] && !namesLayer(rel, specs) && !namesLayer(l.path, include) {
+		return false
+	}
+
+	if len(include) > 0 && !matchPathspec(l.path, include) {
+		return false
+	}
+
+	if len(exclude) > 0 && matchPathspec(l.path, exclude) {
+		return false
+	}
+
+	return true
+}
+
+func bblayersSection(layers []layer, prefix string) string {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "%s\n", bblayersBegin)
+	fmt.Fprintf(buf, "# Generated by gorepo. Changes in this section are overwritten.\n")
+	fmt.Fprintf(buf, "BBLAYERS = \" \\\n")
+	for _, l := range layers {
+		fmt.Fprintf(buf, "  %s/%s \\\n", strings.TrimSuffix(prefix, "/"), l.path)
+	}
+	fmt.Fprintf(buf, "  \"\n")
+	fmt.Fprintf(buf, "%s\n", bblayersEnd)
+
+	return buf.String()
+}
+
+// replaceSection replaces the marked section in the content, or appends it if
+// there is none. Everything outside the section is kept.
+func replaceSection(content, section string) (string, error) {
+	begin := strings.Index(content, bblayersBegin)
+	end := strings.Index(content, bblayersEnd)
+
+	switch {
+	case begin < 0 && end < 0:
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return content + section, nil
+	case begin < 0 || end < begin:
+		return "", fmt.Errorf("The section of gorepo is broken")
+	}
+
+	end += len(bblayersEnd)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+
+	return content[:begin] + section + content[end:], nil
+}
+
+func cmdYoctoBblayers(ctx *cli.Context) error {
+	ylog := log.WithFields(log.Fields{
+		"cmd": "yocto bblayers",
+	})
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	layers, err := discoverLayers(m, ylog)
+	if err != nil {
+		return err
+	}
+
+	var used []layer
+	for _, l := range layers {
+		if useLayer(l, ctx.StringSlice("include"), ctx.StringSlice("exclude")) {
+			used = append(used, l)
+		} else {
+			ylog.Debugf("Skip layer %s", l.path)
+		}
+	}
+
+	section := bblayersSection(used, ctx.String("prefix"))
+
+	output := ctx.String("output")
+	if output == "-" {
+		fmt.Printf("%s", section)
+		return nil
+	}
+
+	// The default output is relative to the top directory
+	if !ctx.IsSet("output") {
+		output = filepath.Join(ProjectRoot, output)
+	}
+
+	content := bblayersHeader
+	if data, err := os.ReadFile(output); err == nil {
+		content = string(data)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Fail to read %s: %s", output, err)
+	}
+
+	content, err = replaceSection(content, section)
+	if err != nil {
+		return fmt.Errorf("Fail to update %s: %s", output, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("Fail to create dir: %s", err)
+	}
+
+	if err := os.WriteFile(output, []byte(content), 0644); err != nil {
+		return fmt.Errorf("Fail to write %s: %s", output, err)
+	}
+
+	ylog.Infof("Write %d layer(s) to %s", len(used), output)
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// nestedLayout creates poky with its own layers, and meta-foo nested in it as
+// another project.
+func nestedLayout(t *testing.T) *Manifest {
+	ProjectRoot = t.TempDir()
+
+	confs := map[string]string{
+		"poky/meta":                  "BBFILE_COLLECTIONS += \"core\"\nLAYERSERIES_CORENAMES = \"kirkstone\"\nLAYERSERIES_COMPAT_core = \"kirkstone\"\n",
+		"poky/meta-poky":             "BBFILE_COLLECTIONS += \"yocto\"\nLAYERDEPENDS_yocto = \"core\"\nLAYERSERIES_COMPAT_yocto = \"kirkstone\"\n",
+		"poky/meta-foo":              "BBFILE_COLLECTIONS += \"foo\"\nLAYERDEPENDS_foo = \"core\"\nLAYERSERIES_COMPAT_foo = \"kirkstone\"\n",
+		"poky/meta-foo/meta-foo-bsp": "BBFILE_COLLECTIONS += \"foo-bsp\"\nLAYERDEPENDS_foo-bsp = \"foo\"\nLAYERSERIES_COMPAT_foo-bsp = \"kirkstone\"\n",
+	}
+	for dir, content := range confs {
+		confDir := filepath.Join(ProjectRoot, dir, "conf")
+		if err := os.MkdirAll(confDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(confDir, "layer.conf"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Manifest{
+		Projects: []Project{
+			{Name: "poky", Path: "poky"},
+			{Name: "meta-foo", Path: "poky/meta-foo"},
+		},
+	}
+}
+
+func TestDiscoverLayersNested(t *testing.T) {
+	oldRoot := ProjectRoot
+	defer func() { ProjectRoot = oldRoot }()
+	m := nestedLayout(t)
+
+	layers, err := discoverLayers(m, log.WithFields(log.Fields{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, l := range layers {
+		if owner, ok := got[l.path]; ok {
+			t.Errorf("%s is found in both %s and %s", l.path, owner, l.project.Name)
+		}
+		got[l.path] = l.project.Name
+	}
+
+	want := map[string]string{
+		"poky/meta":                  "poky",
+		"poky/meta-poky":             "poky",
+		"poky/meta-foo":              "meta-foo",
+		"poky/meta-foo/meta-foo-bsp": "meta-foo",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCheckLayersNested(t *testing.T) {
+	oldRoot := ProjectRoot
+	defer func() { ProjectRoot = oldRoot }()
+	m := nestedLayout(t)
+
+	layers, err := discoverLayers(m, log.WithFields(log.Fields{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confs, err := readLayerConfs(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkLayers(confs)
+	for _, lc := range confs {
+		for _, p := range lc.problems {
+			t.Errorf("%s: %s", lc.path, p)
+		}
+	}
+}
+
+func TestFindLayersSkipsTestData(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{
+		"poky/meta",
+		"poky/meta/lib/oeqa/files/layer",
+		"poky/meta-poky",
+		"poky/meta-poky/recipes-test/meta-inner",
+		"poky/meta-selftest",
+		"poky/meta-skeleton",
+		"poky/bitbake/lib/layerindexlib/tests/testdata/layer1",
+		"meta-arm",
+		"meta-arm/meta-arm-bsp",
+	} {
+		confDir := filepath.Join(root, dir, "conf")
+		if err := os.MkdirAll(confDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(confDir, "layer.conf"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		repo string
+		want []string
+	}{
+		{"poky", []string{"meta", "meta-poky", "meta-selftest", "meta-skeleton"}},
+		{"meta-arm", []string{".", "meta-arm-bsp"}},
+	}
+	for _, tt := range tests {
+		got, err := findLayers(filepath.Join(root, tt.repo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestUseLayerTestLayers(t *testing.T) {
+	poky := &Project{Name: "poky", Path: "poky"}
+	listed := &Project{Name: "poky", Path: "poky", Layers: []string{"meta", "meta-selftest"}}
+
+	tests := []struct {
+		layer   layer
+		include []string
+		want    bool
+	}{
+		{layer{project: poky, path: "poky/meta"}, nil, true},
+		{layer{project: poky, path: "poky/meta-selftest"}, nil, false},
+		{layer{project: poky, path: "poky/meta-skeleton"}, []string{"poky"}, false},
+		{layer{project: poky, path: "poky/meta-skeleton"}, []string{"meta-skeleton"}, true},
+		{layer{project: listed, path: "poky/meta-selftest"}, nil, true},
+	}
+	for _, tt := range tests {
+		if got := useLayer(tt.layer, tt.include, nil); got != tt.want {
+			t.Errorf("%s with %v: got %v, want %v", tt.layer.path, tt.include, got, tt.want)
+		}
+	}
+}
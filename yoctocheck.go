@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var CmdYoctoCheck = cli.Command{
+	Name:  "check",
+	Usage: "Check dependencies and release series of layers of synced projects",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "include",
+			Usage:   "Only check layers matching the pathspec (directory or glob)",
+			Aliases: []string{"i"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "exclude",
+			Usage:   "Don't check layers matching the pathspec (directory or glob)",
+			Aliases: []string{"x"},
+		},
+	},
+	Action: cmdYoctoCheck,
+}
+
+// Assignments in layer.conf, like 'LAYERDEPENDS_foo = "core"'
+var assignRegexp = regexp.MustCompile(`^([A-Za-z0-9_\-.:${}/]+)\s*(\?\?=|\?=|:=|\+=|=\+|\.=|=\.|=)\s*(?:"([^"]*)"|'([^']*)')\s*$`)
+
+// Dependencies with optional version constraints, like 'core (>= 12)'
+var dependRegexp = regexp.MustCompile(`([^\s()]+)(?:\s*\(\s*(>=|<=|=|>|<)?\s*([^\s)]+)\s*\))?`)
+
+type layerDepend struct {
+	name    string
+	op      string
+	version string
+}
+
+type layerConf struct {
+	layer
+	vars        map[string]string
+	collections []string
+	problems    []string
+}
+
+// parseLayerConf reads assignments of variables in layer.conf. Anything else,
+// like includes and python code, is ignored.
+func parseLayerConf(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := ""
+	for scanner.Scan() {
+		text := scanner.Text()
+		// Continued lines
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\")
+			continue
+		}
+		line = strings.TrimSpace(line + text)
+		text, line = line, ""
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		match := assignRegexp.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+
+		name, op, value := match[1], match[2], match[3]+match[4]
+		value = strings.Join(strings.Fields(value), " ")
+		old, exists := vars[name]
+		switch op {
+		case "=", ":=":
+			vars[name] = value
+		case "?=", "??=":
+			if !exists {
+				vars[name] = value
+			}
+		case "+=":
+			vars[name] = strings.TrimSpace(old + " " + value)
+		case "=+":
+			vars[name] = strings.TrimSpace(value + " " + old)
+		case ".=":
+			vars[name] = old + value
+		case "=.":
+			vars[name] = value + old
+		}
+	}
+
+	return vars, scanner.Err()
+}
+
+func parseDepends(value string) []layerDepend {
+	var deps []layerDepend
+	for _, match := range dependRegexp.FindAllStringSubmatch(value, -1) {
+		d := layerDepend{
+			name:    match[1],
+			op:      match[2],
+			version: match[3],
+		}
+		if d.version != "" && d.op == "" {
+			d.op = "="
+		}
+		deps = append(deps, d)
+	}
+
+	return deps
+}
+
+// compareVersions compares layer versions numerically if possible.
+func compareVersions(a, b string) int {
+	x, errX := strconv.Atoi(a)
+	y, errY := strconv.Atoi(b)
+	if errX != nil || errY != nil {
+		return strings.Compare(a, b)
+	}
+
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+
+	return 0
+}
+
+func (d *layerDepend) satisfiedBy(version string) bool {
+	if d.op == "" {
+		return true
+	}
+	if version == "" {
+		return false
+	}
+
+	c := compareVersions(version, d.version)
+	switch d.op {
+	case "=":
+		return c == 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+
+	return false
+}
+
+func (d *layerDepend) String() string {
+	if d.op == "" {
+		return d.name
+	}
+
+	return fmt.Sprintf("%s (%s %s)", d.name, d.op, d.version)
+}
+
+func (lc *layerConf) addProblem(format string, a ...any) {
+	lc.problems = append(lc.problems, fmt.Sprintf(format, a...))
+}
+
+// checkLayers checks every dependency of layers is satisfied by one of them,
+// and every layer is compatible with the release series of the core layer.
+// Problems are recorded in each layer.
+func checkLayers(confs []*layerConf) {
+	// Collections and their versions provided by layers
+	provided := make(map[string]*layerConf)
+	for _, lc := range confs {
+		for _, c := range lc.collections {
+			if other, ok := provided[c]; ok {
+				lc.addProblem("Collection '%s' is also provided by %s", c, other.path)
+				continue
+			}
+			provided[c] = lc
+		}
+	}
+
+	// Series supported by the core layer. Without it, all layers must share
+	// at least one series.
+	var coreNames []string
+	for _, lc := range confs {
+		if v, ok := lc.vars["LAYERSERIES_CORENAMES"]; ok {
+			coreNames = strings.Fields(v)
+			break
+		}
+	}
+	shared := coreNames
+
+	for _, lc := range confs {
+		if len(lc.collections) == 0 {
+			lc.addProblem("No BBFILE_COLLECTIONS is set")
+		}
+
+		for _, c := range lc.collections {
+			for _, d := range parseDepends(lc.vars["LAYERDEPENDS_"+c]) {
+				p, ok := provided[d.name]
+				if !ok {
+					lc.addProblem("Dependency '%s' of '%s' is not provided by any layer", d.String(), c)
+					continue
+				}
+
+				version := p.vars["LAYERVERSION_"+d.name]
+				if !d.satisfiedBy(version) {
+					lc.addProblem("Dependency '%s' of '%s' is not satisfied by %s (version '%s')",
+						d.String(), c, p.path, version)
+				}
+			}
+
+			compat, ok := lc.vars["LAYERSERIES_COMPAT_"+c]
+			if !ok {
+				lc.addProblem("LAYERSERIES_COMPAT_%s is not set", c)
+				continue
+			}
+
+			series := strings.Fields(compat)
+			if coreNames != nil {
+				if len(intersect(series, coreNames)) == 0 {
+					lc.addProblem("'%s' is compatible with '%s', but not with the core series '%s'",
+						c, compat, strings.Join(coreNames, " "))
+				}
+				continue
+			}
+
+			if shared == nil {
+				shared = series
+			} else {
+				shared = intersect(shared, series)
+			}
+		}
+	}
+
+	if coreNames == nil && shared != nil && len(shared) == 0 {
+		for _, lc := range confs {
+			lc.addProblem("No release series is shared by all layers")
+		}
+	}
+}
+
+func intersect(a, b []string) []string {
+	out := []string{}
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				out = append(out, x)
+				break
+			}
+		}
+	}
+
+	return out
+}
+
+func readLayerConfs(layers []layer) ([]*layerConf, error) {
+	var confs []*layerConf
+	for _, l := range layers {
+		vars, err := parseLayerConf(filepath.Join(ProjectRoot, l.path, "conf", "layer.conf"))
+		if err != nil {
+			return nil, fmt.Errorf("Fail to read layer.conf of %s: %s", l.path, err)
+		}
+
+		confs = append(confs, &layerConf{
+			layer:       l,
+			vars:        vars,
+			collections: strings.Fields(vars["BBFILE_COLLECTIONS"]),
+		})
+	}
+
+	return confs, nil
+}
+
+func cmdYoctoCheck(ctx *cli.Context) error {
+	ylog := log.WithFields(log.Fields{
+		"cmd": "yocto check",
+	})
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("Fail to load config: %s", err)
+	}
+
+	filePath := filepath.Join(ConfDir, cfg.Manifest.Path, cfg.Manifest.File)
+	m, err := LoadManifest(filePath)
+	if err != nil {
+		return fmt.Errorf("Fail to load manifest: %s", err)
+	}
+
+	layers, err := discoverLayers(m, ylog)
+	if err != nil {
+		return err
+	}
+
+	var used []layer
+	for _, l := range layers {
+		if !useLayer(l, ctx.StringSlice("include"), ctx.StringSlice("exclude")) {
+			ylog.Debugf("Skip layer %s", l.path)
+			continue
+		}
+		used = append(used, l)
+	}
+
+	confs, err := readLayerConfs(used)
+	if err != nil {
+		return err
+	}
+
+	checkLayers(confs)
+
+	failed := 0
+	for _, lc := range confs {
+		name := strings.Join(lc.collections, " ")
+		if len(lc.problems) == 0 {
+			fmt.Printf("%s (%s): OK\n", lc.path, name)
+			continue
+		}
+
+		failed++
+		fmt.Printf("%s (%s):\n", lc.path, name)
+		for _, p := range lc.problems {
+			fmt.Printf("  - %s\n", p)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d layer(s) have problems", failed, len(confs))
+	}
+
+	return nil
+}